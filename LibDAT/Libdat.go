@@ -35,6 +35,55 @@ type Marker struct {
 	Ended bool
 }
 
+// NewStatus decodes the status letter FactoryTalk View writes with each value
+func NewStatus(b byte) Status {
+	switch b {
+	case 'E':
+		return Status{CommunicationError: true}
+	case 'D':
+		return Status{Disabled: true}
+	case 'S':
+		return Status{Stale: true}
+	case 'U':
+		return Status{Uninitialized: true}
+	default:
+		return Status{Good: true}
+	}
+}
+
+// String provides a string representation of the Status
+func (s Status) String() string {
+	switch {
+	case s.CommunicationError:
+		return "CommunicationError"
+	case s.Disabled:
+		return "Disabled"
+	case s.Stale:
+		return "Stale"
+	case s.Uninitialized:
+		return "Uninitialized"
+	default:
+		return "Good"
+	}
+}
+
+// NewMarker decodes the marker letter that flags the first and last value of a logging session
+func NewMarker(b byte) Marker {
+	return Marker{Began: b == 'B', Ended: b == 'E'}
+}
+
+// String provides a string representation of the Marker
+func (m Marker) String() string {
+	switch {
+	case m.Began:
+		return "Began"
+	case m.Ended:
+		return "Ended"
+	default:
+		return ""
+	}
+}
+
 func (dr *DatReader) ReadFloatFileHeader(filename string) (*int32, error) {
 
 	// Open the float file
@@ -170,6 +219,145 @@ func readNextDatFloatRecord(r io.Reader) (*DatFloatRecord, error) {
 	}, nil
 }
 
+type DatStringRecord struct {
+	TimeStamp time.Time
+	TagID     int
+	Val       string
+	Status    byte
+	Marker    byte
+	IsValid   bool
+}
+
+// dbfField is the position of a column inside a fixed width DAT record
+type dbfField struct {
+	offset int
+	length int
+}
+
+// readFieldLayout reads the dBase header of a DAT file and returns the header length,
+// record length and the position of each column by name
+func readFieldLayout(r io.Reader) (int, int, map[string]dbfField, error) {
+	header := make([]byte, 32)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, nil, fmt.Errorf("failed to read file header: %v", err)
+	}
+	headerLen := int(binary.LittleEndian.Uint16(header[8:10]))
+	recordLen := int(binary.LittleEndian.Uint16(header[10:12]))
+
+	fields := make(map[string]dbfField)
+	offset := 1 // Each record starts with the deletion flag
+	descriptor := make([]byte, 32)
+	for pos := 32; pos+32 < headerLen; pos += 32 {
+		if _, err := io.ReadFull(r, descriptor); err != nil {
+			return 0, 0, nil, fmt.Errorf("failed to read field descriptor: %v", err)
+		}
+		if descriptor[0] == 0x0D {
+			break
+		}
+		name := strings.TrimRight(string(descriptor[0:11]), "\x00 ")
+		length := int(descriptor[16])
+		fields[strings.ToUpper(name)] = dbfField{offset: offset, length: length}
+		offset += length
+	}
+
+	return headerLen, recordLen, fields, nil
+}
+
+// ReadStringFile reads the string file that belongs to a float file. If the datalog
+// has no string file the returned error matches os.ErrNotExist.
+func (dr *DatReader) ReadStringFile(floatfileName string) ([]*DatStringRecord, error) {
+	stringfileName := strings.Replace(floatfileName, " (Float)", " (String)", 1)
+
+	file, err := os.Open(stringfileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open string file: %w", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, fmt.Errorf("failed to read string file header: %v", err)
+	}
+	rowCount := int32(binary.LittleEndian.Uint32(header[4:8]))
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	headerLen, recordLen, fields, err := readFieldLayout(file)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := fields["VALUE"]
+	if !ok || value.offset+value.length > recordLen || recordLen < 25 {
+		return nil, fmt.Errorf("string file %s has an unexpected record layout", stringfileName)
+	}
+	status := fields["STATUS"]
+	marker := fields["MARKER"]
+
+	if _, err := file.Seek(int64(headerLen), io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek to string records: %v", err)
+	}
+
+	records := make([]*DatStringRecord, 0, rowCount)
+	buffer := make([]byte, recordLen)
+	for i := 0; i < int(rowCount); i++ {
+		if _, err := io.ReadFull(file, buffer); err != nil {
+			slog.Error(fmt.Sprintf("Error reading string record: %v", err))
+			break
+		}
+
+		timestamp, tagID, err := parseRecordKey(buffer)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error reading string record: %v", err))
+			continue
+		}
+
+		rec := &DatStringRecord{
+			TimeStamp: timestamp,
+			TagID:     tagID,
+			Val:       strings.TrimRight(string(buffer[value.offset:value.offset+value.length]), "\x00 "),
+			IsValid:   true,
+		}
+		if status.length > 0 {
+			rec.Status = buffer[status.offset]
+		}
+		if marker.length > 0 {
+			rec.Marker = buffer[marker.offset]
+		}
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
+
+// parseRecordKey decodes the Date, Time, Millitm and TagIndex columns shared by float and string records
+func parseRecordKey(buffer []byte) (time.Time, int, error) {
+	datetime, err := time.Parse("2006010215:04:05", string(buffer[1:17]))
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	milli, err := strconv.Atoi(strings.TrimSpace(string(buffer[17:20])))
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("failed to read milliseconds: %v", err)
+	}
+	datetime = datetime.Add(time.Duration(milli) * time.Millisecond)
+
+	tagID, err := strconv.Atoi(strings.TrimSpace(string(buffer[20:25])))
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("failed to read tag index: %v", err)
+	}
+
+	return datetime, tagID, nil
+}
+
+// Tag types of the (Tagname) file
+const (
+	TagTypeAnalog  = 1
+	TagTypeDigital = 2
+	TagTypeString  = 3
+)
+
 type DatTagRecord struct {
 	Name  string
 	ID    int
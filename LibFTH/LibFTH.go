@@ -5,17 +5,8 @@ package LibFTH
 
 #include <stdint.h>
 #include <stdlib.h>
+#include "pitimestamp.h"
 
-// C function prototypes
-struct PITIMESTAMP {
-    int16_t year;
-    int16_t month;
-    int16_t day;
-    int16_t hour;
-    int16_t minute;
-    int16_t second;
-    int32_t subsecond;
-};
 extern int32_t piut_setservernode(const char* name);
 extern int32_t piut_disconnect();
 extern void piut_setprocname(const char* name);
@@ -38,102 +29,243 @@ import (
 	"github.com/complacentsee/goDatalogConvert/LibPI"
 )
 
-var piapidll sync.Mutex
-var mu sync.Mutex
+// historianCache and missingPoints are only used on the DLL worker, pointNames is read by the result accounting too
 var historianCache = make(map[string]LibPI.HistorianPoint)
+var missingPoints = make(map[string]error)
+var pointNames sync.Map
 
-func Connect(serverName string) error {
-	piapidll.Lock()
-	defer piapidll.Unlock()
-	cServerName := C.CString(serverName)
+// warnedPoints keeps AddToPIPointCache from warning about a missing point or one of unknown type for every file
+var warnedPoints sync.Map
+
+// batchSize is the largest number of values sent in one pisn_putsnapshotsx call, 0 sends a whole file at once
+var batchSize = 10000
+
+// SetBatchSize sets the largest number of values sent in one pisn_putsnapshotsx call. Like the
+// other settings of the package it must be called before the first write.
+func SetBatchSize(size int) {
+	batchSize = size
+}
+
+func Connect(name string) error {
+	var err error
+	do(requestAdmin, func() {
+		if err = connectOnWorker(name); err == nil && name != serverName {
+			forgetPointsOnWorker()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	serverName = name
+	return nil
+}
+
+// forgetPointsOnWorker drops what was looked up on another server, point numbers differ between servers
+func forgetPointsOnWorker() {
+	historianCache = make(map[string]LibPI.HistorianPoint)
+	missingPoints = make(map[string]error)
+	pointNames.Range(func(key, _ any) bool {
+		pointNames.Delete(key)
+		return true
+	})
+	latestTimes.Range(func(key, _ any) bool {
+		latestTimes.Delete(key)
+		return true
+	})
+}
+
+func connectOnWorker(name string) error {
+	cServerName := C.CString(name)
 	defer C.free(unsafe.Pointer(cServerName))
 
 	err := C.piut_setservernode(cServerName)
 	if err != 0 {
-		return fmt.Errorf("piut_setservernode returned error %d", err)
+		return fmt.Errorf("failed to connect to %s: %w", name, newPIErrorOnWorker("piut_setservernode", int32(err)))
 	}
-	return nil
+	return loginOnWorker()
 }
 
 func SetProcessName(processName string) {
-	piapidll.Lock()
-	defer piapidll.Unlock()
-	cProcessName := C.CString(processName)
-	defer C.free(unsafe.Pointer(cProcessName))
-	C.piut_setprocname(cProcessName)
+	do(requestAdmin, func() {
+		cProcessName := C.CString(processName)
+		defer C.free(unsafe.Pointer(cProcessName))
+		C.piut_setprocname(cProcessName)
+	})
 }
 
 func Disconnect() error {
-	piapidll.Lock()
-	defer piapidll.Unlock()
-	err := C.piut_disconnect()
-	if err != 0 {
-		return fmt.Errorf("piut_setservernode returned error %d", err)
-	}
-	return nil
+	var err error
+	do(requestAdmin, func() {
+		if code := C.piut_disconnect(); code != 0 {
+			err = newPIErrorOnWorker("piut_disconnect", int32(code))
+		}
+	})
+	return err
 }
 
 func GetPointNumber(ptName string) (int32, error) {
-	mu.Lock()
-	if point, ok := historianCache[ptName]; ok {
-		mu.Unlock()
-		return point.PIId, nil
-	}
-	mu.Unlock()
-
-	piapidll.Lock()
-	defer piapidll.Unlock()
 	if len(ptName) > 80 {
 		return 0, fmt.Errorf("historian point name %s > 80 characters not supported", ptName)
 	}
 
-	cPtName := C.CString(ptName)
-	defer C.free(unsafe.Pointer(cPtName))
+	var ptNumber int32
+	var err error
+	do(requestLookup, func() {
+		if point, ok := historianCache[ptName]; ok {
+			ptNumber = point.PIId
+			return
+		}
+		if missing, ok := missingPoints[ptName]; ok {
+			err = missing
+			return
+		}
 
-	var pointNumber C.int32_t
-	err := C.pipt_findpoint(cPtName, &pointNumber)
-	if err != 0 {
-		return 0, fmt.Errorf("error finding historian point %s, pipt_findpoint returned error %d", ptName, err)
+		cPtName := C.CString(ptName)
+		defer C.free(unsafe.Pointer(cPtName))
+
+		var pointNumber C.int32_t
+		if code := C.pipt_findpoint(cPtName, &pointNumber); code != 0 {
+			piErr := newPIErrorOnWorker("pipt_findpoint", int32(code))
+			err = fmt.Errorf("error finding historian point %s: %w", ptName, piErr)
+			if piErr.Class == ErrorClassPoint {
+				missingPoints[ptName] = err
+			}
+			return
+		}
+
+		ptNumber = int32(pointNumber)
+		historianCache[ptName] = LibPI.HistorianPoint{PIId: ptNumber}
+		pointNames.Store(ptNumber, ptName)
+	})
+	return ptNumber, err
+}
+
+// GetPointType looks up the type of a point with pipt_pointtype
+func GetPointType(ptNumber int32) (LibPI.PointType, error) {
+	var pointType C.char
+	var err error
+	do(requestLookup, func() {
+		if code := C.pipt_pointtype(C.int32_t(ptNumber), &pointType); code != 0 {
+			err = fmt.Errorf("error looking up type of point %d: %w", ptNumber, newPIErrorOnWorker("pipt_pointtype", int32(code)))
+		}
+	})
+	if err != nil {
+		return LibPI.PointTypeUnknown, err
 	}
 
-	ptNumber := int32(pointNumber)
+	switch pointType {
+	case 'R':
+		return LibPI.PointTypeReal, nil
+	case 'I':
+		return LibPI.PointTypeInteger, nil
+	case 'D':
+		return LibPI.PointTypeDigital, nil
+	default:
+		return LibPI.PointTypeUnknown, nil
+	}
+}
 
-	mu.Lock()
-	historianCache[ptName] = LibPI.HistorianPoint{PIId: ptNumber}
-	mu.Unlock()
-	return ptNumber, nil
+// PointName returns the name a point number was resolved from
+func PointName(ptNumber int32) string {
+	if name, ok := pointNames.Load(ptNumber); ok {
+		return name.(string)
+	}
+	return ""
 }
 
-func PutSnapshots(count int32, ptids []int32, vs []float64, ts []LibPI.PITIMESTAMP) (time.Duration, error) {
-	start := time.Now()
-	piapidll.Lock()
-	waitDuration := time.Since(start)
-	defer piapidll.Unlock()
-	ivals := make([]C.int32_t, count)
+// PutSnapshots sends one batch through pisn_putsnapshotsx. Values the historian rejects are
+// reported per point and error code in the result, the error is only set when the call itself failed.
+func PutSnapshots(count int32, ptids []int32, vs []float64, ivals []int32, istats []int32, ts []LibPI.PITIMESTAMP) (*WriteResult, error) {
+	result := NewWriteResult()
+	result.Batches = 1
+	result.Sent = int(count)
 	bsizes := make([]C.uint32_t, count)
-	istats := make([]C.int32_t, count)
 	flags := make([]C.int16_t, count)
 	errors := make([]C.int32_t, count)
 
 	cPtids := (*C.int32_t)(unsafe.Pointer(&ptids[0]))
 	cVs := (*C.double)(unsafe.Pointer(&vs[0]))
-	cTs := (*C.struct_PITIMESTAMP)(unsafe.Pointer(&ts[0]))
+	cIvals := (*C.int32_t)(unsafe.Pointer(&ivals[0]))
+	cIstats := (*C.int32_t)(unsafe.Pointer(&istats[0]))
+	cTs := cTimestamps(ts)
+
+	var err error
+	result.Wait = do(requestWrite, func() {
+		start := time.Now()
+		code := C.pisn_putsnapshotsx(C.int32_t(count), cPtids, cVs, cIvals, nil, &bsizes[0], cIstats, &flags[0], cTs, &errors[0])
+		result.Push = time.Since(start)
+		err = accountItemsOnWorker("pisn_putsnapshotsx", int32(code), ptids, ts, errors, result)
+	})
+	return result, err
+}
 
-	err := C.pisn_putsnapshotsx(C.int32_t(count), cPtids, cVs, &ivals[0], nil, &bsizes[0], &istats[0], &flags[0], cTs, &errors[0])
-	if err != 0 {
-		for i := 0; i < int(count); i++ {
-			if errors[i] != 0 && errors[i] != -109 {
-				return waitDuration, fmt.Errorf("pisn_putsnapshotsx returned error %d, item %d, ts %v, err %d", err, i, ts[i], errors[i])
+// WriteTestValue writes the snapshot value of a point again at the current time with
+// pisn_putsnapshotsx. It adds an event to the point, so it is only done when asked for.
+func WriteTestValue(ptNumber int32) error {
+	snapshot, err := Snapshot(ptNumber)
+	if err != nil {
+		return err
+	}
+	ts := []LibPI.PITIMESTAMP{LibPI.NewPITIMESTAMP(time.Now())}
+	result, err := PutSnapshots(1, []int32{ptNumber}, []float64{snapshot.Value}, []int32{snapshot.Ival}, []int32{snapshot.Istat}, ts)
+	if err != nil {
+		return err
+	}
+	if len(result.retry) > 0 {
+		return LookupError(result.retry[0].code)
+	}
+	return result.Err()
+}
+
+// accountItemsOnWorker sorts the per value error codes of a bulk write into result. Values that
+// failed with retryable errors are left in result.retry. The returned error is only set when the
+// call failed as a whole.
+func accountItemsOnWorker(call string, err int32, ptids []int32, ts []LibPI.PITIMESTAMP, errors []C.int32_t, result *WriteResult) error {
+	if err == 0 {
+		result.Succeeded = len(ptids)
+		return nil
+	}
+
+	itemErrors := false
+	for i := range ptids {
+		switch errors[i] {
+		case 0:
+			result.Succeeded++
+		case -109:
+			itemErrors = true
+			result.Ignored++
+		default:
+			itemErrors = true
+			if errorClass(int32(errors[i])) == ErrorClassRetryable {
+				result.retry = append(result.retry, retryItem{index: i, code: int32(errors[i])})
+				continue
 			}
+			result.AddFailure(ptids[i], int32(errors[i]), ts[i].Time())
 		}
 	}
-	return waitDuration, nil
+
+	// The call failed without blaming any value, so none of them were written
+	if !itemErrors {
+		retryable := errorClass(err) == ErrorClassRetryable
+		for i := range ptids {
+			if retryable {
+				result.retry = append(result.retry, retryItem{index: i, code: err})
+				continue
+			}
+			result.AddFailure(ptids[i], err, ts[i].Time())
+		}
+		return newPIErrorOnWorker(call, err)
+	}
+	return nil
 }
 
 func AddToPIPointCache(datalogName string, datalogID int, datalogType int, piPointName string) *LibPI.PointCache {
 	slog.Debug(fmt.Sprintf("Looking up PI Point %s", piPointName))
 	PIPointID, err := GetPointNumber(piPointName)
 	if err != nil {
+		if _, warned := warnedPoints.LoadOrStore(piPointName, true); !warned {
+			slog.Warn(fmt.Sprintf("%v, its values will not be written", err))
+		}
 		return &LibPI.PointCache{
 			DatalogName: datalogName,
 			DataLogID:   datalogID,
@@ -143,18 +275,37 @@ func AddToPIPointCache(datalogName string, datalogID int, datalogType int, piPoi
 		}
 	}
 
-	// TODO: Confirm that types are compatible. EG: Don't assume all data is float/real
-	// slog.Debug(fmt.Sprintf("Looking up PI Type for PI ID %d", PIPointID))
-	// if err != nil {
-	// 	return &LibPI.PointCache{
-	// 		DatalogName: datalogName,
-	// 		DataLogID:   datalogID,
-	// 		DataLogType: datalogType,
-	// 		Process:     false,
-	// 		PIName:      piPointName,
-	// 		PIId:        &PIPointID,
-	// 	}
-	// }
+	// Values of points of unknown type are not sent, they are left out like those of missing points
+	// instead of each being counted as not converted
+	pointType, err := GetPointType(PIPointID)
+	if err != nil || pointType == LibPI.PointTypeUnknown {
+		if _, warned := warnedPoints.LoadOrStore(piPointName, true); !warned {
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Could not look up the type of PI Point %s, its values will not be written: %v", piPointName, err))
+			} else {
+				slog.Warn(fmt.Sprintf("PI Point %s is not a real, integer or digital point, its values will not be written", piPointName))
+			}
+		}
+		return &LibPI.PointCache{
+			DatalogName: datalogName,
+			DataLogID:   datalogID,
+			DataLogType: 0,
+			Process:     false,
+			PIName:      piPointName,
+			PIId:        &PIPointID,
+			PIType:      pointType,
+		}
+	}
+
+	var latest time.Time
+	if skipExisting {
+		latest, err = latestTime(PIPointID)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Could not look up the newest value of PI Point %s, all of its values will be written: %v", piPointName, err))
+		} else {
+			slog.Debug(fmt.Sprintf("PI Point %s holds values up to %s", piPointName, latest.Format("2006-01-02 15:04:05.000")))
+		}
+	}
 
 	return &LibPI.PointCache{
 		DatalogName: datalogName,
@@ -163,81 +314,106 @@ func AddToPIPointCache(datalogName string, datalogID int, datalogType int, piPoi
 		Process:     true,
 		PIName:      piPointName,
 		PIId:        &PIPointID,
+		PIType:      pointType,
+		Latest:      latest,
 	}
 }
 
-func ConvertDatFloatRecordsToPutSnapshots(records []*LibDAT.DatFloatRecord, pointLookup *LibPI.PointLookup) error {
+func ConvertDatFloatRecordsToPutSnapshots(records []*LibDAT.DatFloatRecord, pointLookup *LibPI.PointLookup) (*WriteResult, error) {
 	// Prepare slices for PutSnapshots inputs
 	ptids := make([]int32, 0, len(records))
 	vs := make([]float64, 0, len(records))
+	ivals := make([]int32, 0, len(records))
+	istats := make([]int32, 0, len(records))
 	ts := make([]LibPI.PITIMESTAMP, 0, len(records))
 	var count int32 = 0
 	start := time.Now()
+	result := NewWriteResult()
 
 	for _, record := range records {
 		if record == nil {
 			continue // Skip nil records to avoid dereferencing nil pointers
 		}
 
-		// Use the point lookup to get the PI Point ID
-		piPointID, exists := pointLookup.GetPointIDByDataLogID(record.TagID)
-		if !exists || piPointID == nil {
+		// Use the point lookup to get the PI Point
+		point, exists := pointLookup.GetPointByDataLogID(record.TagID)
+		if !exists || !point.Process || point.PIId == nil {
+			continue
+		}
+		if !point.Latest.IsZero() && !record.TimeStamp.After(point.Latest) {
+			result.Skipped++
+			continue
+		}
+
+		// Integer points take the value through ival and digital points through istat
+		ival, istat, ok := ConvertValue(point, record.Val)
+		if !ok {
+			result.AddMismatch(point, record.Val, record.TimeStamp)
 			continue
 		}
 
 		piTimestamp := LibPI.NewPITIMESTAMP(record.TimeStamp)
 
 		// Append the mapped values to the slices
-		ptids = append(ptids, *piPointID)
+		ptids = append(ptids, *point.PIId)
 		vs = append(vs, record.Val)
+		ivals = append(ivals, ival)
+		istats = append(istats, istat)
 		ts = append(ts, piTimestamp)
 		count++
 	}
 
 	if count < 1 {
-		return fmt.Errorf("no valid entries to push to historian")
+		if result.Mismatched > 0 || result.Skipped > 0 {
+			return result, result.Err()
+		}
+		return nil, fmt.Errorf("no valid entries to push to historian")
 	}
 
-	waitingDuration, err := PutSnapshots(count, ptids, vs, ts)
-	duration := time.Since(start)
+	if replaceExisting {
+		blocked := replaceWindows(ptids, ts, result)
+		if replaceDryRun {
+			return result, nil
+		}
+		if len(blocked) > 0 {
+			ptids, vs, ivals, istats, ts = dropBlocked(blocked, ptids, vs, ivals, istats, ts, result)
+			count = int32(len(ptids))
+			if count < 1 {
+				return result, result.Err()
+			}
+		}
+	}
 
-	slog.Info(fmt.Sprintf("Pushed %d records to historian in %.2f seconds, waited %.2f seconds", count, duration.Seconds()-waitingDuration.Seconds(), waitingDuration.Seconds()))
+	// Send the values in batches, other requests to the DLL worker are served between them
+	mode := writeMode
+	for i, end := 0, 0; i < int(count); i = end {
+		size := nextBatchSize(int(count) - i)
+		end = min(i+size, int(count))
+		batch, err := putBatchWithRetry(mode, ptids[i:end], vs[i:end], ivals[i:end], istats[i:end], ts[i:end])
+		observeBatch(size, batch)
+		result.Merge(batch)
+		slog.Debug(fmt.Sprintf("Batch %d: pushed %d records in %.3f seconds, waited %.3f seconds, %d failed",
+			result.Batches, batch.Sent, batch.Push.Seconds(), batch.Wait.Seconds(), batch.Failed))
+		if err != nil {
+			slog.Error(fmt.Sprintf("Batch %d of values %d-%d: %v", result.Batches, i, end-1, err))
+		}
+	}
+	slog.Debug(fmt.Sprintf("Pushed %d records to historian in %.2f seconds", count, time.Since(start).Seconds()))
 
-	return err
+	return result, result.Err()
 }
 
-// func ConvertDatFloatRecordsToPutSnapshots(records []*LibDAT.DatFloatRecord, pointLookup *LibPI.PointLookup) error {
-// 	// Prepare slices for PutSnapshots inputs
-// 	var ptids []int32
-// 	var vs []float64
-// 	var ts []LibPI.PITIMESTAMP
-// 	var count int32 = 0
-
-// 	for i, record := range records {
-
-// 		// Use the point lookup to get the PI Point ID
-// 		piPointID, exists := pointLookup.GetPointIDByDataLogID(record.TagID)
-// 		if !exists {
-// 			continue
-// 			//return fmt.Errorf("point ID not found for DataLogID %d", record.TagID)
-// 		}
-// 		if piPointID == nil {
-// 			continue
-// 		}
-
-// 		piTimestamp := LibPI.NewPITIMESTAMP(record.TimeStamp)
-
-// 		// Append the mapped values to the slices
-// 		ptids = append(ptids, *piPointID)
-// 		vs = append(vs, record.Val)
-// 		ts = append(ts, piTimestamp)
-// 		count++
-// 	}
-// 	slog.Info(fmt.Sprintf("Pushing %d records to historian", count))
-// 	if count < 1 {
-// 		return fmt.Errorf("no Valid entries to push to historian")
-// 	}
-
-// 	// Call the PutSnapshots function with the prepared data
-// 	return PutSnapshots(count, ptids, vs, ts)
-// }
+// dropBlocked removes the values of points whose old archive events could not be deleted, and
+// counts them as failed with the code of the delete
+func dropBlocked(blocked map[int32]int32, ptids []int32, vs []float64, ivals []int32, istats []int32, ts []LibPI.PITIMESTAMP, result *WriteResult) ([]int32, []float64, []int32, []int32, []LibPI.PITIMESTAMP) {
+	n := 0
+	for i, id := range ptids {
+		if code, ok := blocked[id]; ok {
+			result.AddFailure(id, code, ts[i].Time())
+			continue
+		}
+		ptids[n], vs[n], ivals[n], istats[n], ts[n] = ptids[i], vs[i], ivals[i], istats[i], ts[i]
+		n++
+	}
+	return ptids[:n], vs[:n], ivals[:n], istats[:n], ts[:n]
+}
@@ -0,0 +1,109 @@
+package LibFTH
+
+/*
+#include "pitimestamp.h"
+
+extern int32_t piar_putarcvaluesx(int32_t count, int32_t mode, int32_t* frombuf, int32_t* ptnum, double* drval, int32_t* ival,
+                                  uint8_t* bval, uint32_t* bsize, int32_t* istat, int16_t* flags, struct PITIMESTAMP* timestamp, int32_t* errors);
+*/
+import "C"
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// Merge modes of piar_putarcvaluesx
+const (
+	arcAppend  = 4
+	arcReplace = 5
+	arcAppendX = 8
+)
+
+// WriteMode selects how values reach the historian
+type WriteMode int
+
+const (
+	// WriteModeSnapshot sends values through the snapshot and compression with pisn_putsnapshotsx
+	WriteModeSnapshot WriteMode = iota
+	// WriteModeArchiveInsert adds values to the archive next to any events already at the same time
+	WriteModeArchiveInsert
+	// WriteModeArchiveReplace adds values to the archive, replacing events already at the same time
+	WriteModeArchiveReplace
+	// WriteModeArchiveInsertNoCompression adds values to the archive without compression
+	WriteModeArchiveInsertNoCompression
+)
+
+// String provides a string representation of the WriteMode
+func (m WriteMode) String() string {
+	switch m {
+	case WriteModeSnapshot:
+		return "snapshot"
+	case WriteModeArchiveInsert:
+		return "insert"
+	case WriteModeArchiveReplace:
+		return "replace"
+	case WriteModeArchiveInsertNoCompression:
+		return "insert-no-compression"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseWriteMode converts a command line value into a WriteMode
+func ParseWriteMode(s string) (WriteMode, error) {
+	for _, m := range []WriteMode{WriteModeSnapshot, WriteModeArchiveInsert, WriteModeArchiveReplace, WriteModeArchiveInsertNoCompression} {
+		if strings.EqualFold(s, m.String()) {
+			return m, nil
+		}
+	}
+	return WriteModeSnapshot, fmt.Errorf("unknown write mode %q, expected snapshot, insert, replace or insert-no-compression", s)
+}
+
+func (m WriteMode) arcMode() C.int32_t {
+	switch m {
+	case WriteModeArchiveReplace:
+		return arcReplace
+	case WriteModeArchiveInsertNoCompression:
+		return arcAppendX
+	default:
+		return arcAppend
+	}
+}
+
+var writeMode = WriteModeSnapshot
+
+// SetWriteMode selects between snapshot writes and the archive merge modes for the run
+func SetWriteMode(mode WriteMode) {
+	writeMode = mode
+}
+
+// PutArchiveValues writes one batch straight into the archive with piar_putarcvaluesx, bypassing the
+// snapshot so values older than the current snapshot are kept. Results are reported like PutSnapshots.
+func PutArchiveValues(count int32, mode WriteMode, ptids []int32, vs []float64, ivals []int32, istats []int32, ts []LibPI.PITIMESTAMP) (*WriteResult, error) {
+	result := NewWriteResult()
+	result.Batches = 1
+	result.Sent = int(count)
+	bsizes := make([]C.uint32_t, count)
+	flags := make([]C.int16_t, count)
+	errors := make([]C.int32_t, count)
+	var frombuf C.int32_t
+
+	cPtids := (*C.int32_t)(unsafe.Pointer(&ptids[0]))
+	cVs := (*C.double)(unsafe.Pointer(&vs[0]))
+	cIvals := (*C.int32_t)(unsafe.Pointer(&ivals[0]))
+	cIstats := (*C.int32_t)(unsafe.Pointer(&istats[0]))
+	cTs := cTimestamps(ts)
+
+	var err error
+	result.Wait = do(requestWrite, func() {
+		start := time.Now()
+		code := C.piar_putarcvaluesx(C.int32_t(count), mode.arcMode(), &frombuf, cPtids, cVs, cIvals, nil, &bsizes[0], cIstats, &flags[0], cTs, &errors[0])
+		result.Push = time.Since(start)
+		err = accountItemsOnWorker("piar_putarcvaluesx", int32(code), ptids, ts, errors, result)
+	})
+	return result, err
+}
@@ -0,0 +1,92 @@
+package LibFTH
+
+/*
+#include <stdint.h>
+
+extern int32_t pipt_compressing(int32_t ptnum, int32_t* compressing);
+extern int32_t pipt_compspecseng(int32_t ptnum, float* compdeveng, int32_t* compmin, int32_t* compmax,
+                                 float* excdeveng, int32_t* excmin, int32_t* excmax);
+extern int32_t pipt_scale(int32_t ptnum, float* zero, float* span);
+extern int32_t pipt_engunitstring(int32_t ptnum, char* engunitstring, int32_t len);
+extern int32_t pipt_rescode(int32_t ptnum, int32_t* rescode);
+*/
+import "C"
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// rescodeStep is the resolution code of points with the step attribute set
+const rescodeStep = 4
+
+// PointAttributes are the point settings that decide how imported values are stored
+type PointAttributes struct {
+	Type        LibPI.PointType
+	Compressing bool
+	// CompDev and ExcDev are in engineering units
+	CompDev float64
+	ExcDev  float64
+	// CompMax is the longest time compression goes without archiving an event
+	CompMax  time.Duration
+	Zero     float64
+	Span     float64
+	EngUnits string
+	Step     bool
+}
+
+// GetPointAttributes reads the attributes of a point with the pipt_* calls
+func GetPointAttributes(ptNumber int32) (PointAttributes, error) {
+	pointType, err := GetPointType(ptNumber)
+	if err != nil {
+		return PointAttributes{}, err
+	}
+
+	attrs := PointAttributes{Type: pointType}
+	do(requestLookup, func() {
+		pt := C.int32_t(ptNumber)
+
+		var compressing C.int32_t
+		if code := C.pipt_compressing(pt, &compressing); code != 0 {
+			err = newPIErrorOnWorker("pipt_compressing", int32(code))
+			return
+		}
+		attrs.Compressing = compressing != 0
+
+		var compDev, excDev C.float
+		var compMin, compMax, excMin, excMax C.int32_t
+		if code := C.pipt_compspecseng(pt, &compDev, &compMin, &compMax, &excDev, &excMin, &excMax); code != 0 {
+			err = newPIErrorOnWorker("pipt_compspecseng", int32(code))
+			return
+		}
+		attrs.CompDev, attrs.ExcDev = float64(compDev), float64(excDev)
+		attrs.CompMax = time.Duration(compMax) * time.Second
+
+		var zero, span C.float
+		if code := C.pipt_scale(pt, &zero, &span); code != 0 {
+			err = newPIErrorOnWorker("pipt_scale", int32(code))
+			return
+		}
+		attrs.Zero, attrs.Span = float64(zero), float64(span)
+
+		buf := make([]byte, 64)
+		if code := C.pipt_engunitstring(pt, (*C.char)(unsafe.Pointer(&buf[0])), C.int32_t(len(buf))); code != 0 {
+			err = newPIErrorOnWorker("pipt_engunitstring", int32(code))
+			return
+		}
+		attrs.EngUnits = cString(buf)
+
+		var rescode C.int32_t
+		if code := C.pipt_rescode(pt, &rescode); code != 0 {
+			err = newPIErrorOnWorker("pipt_rescode", int32(code))
+			return
+		}
+		attrs.Step = rescode == rescodeStep
+	})
+	if err != nil {
+		return PointAttributes{}, fmt.Errorf("error reading attributes of %s: %w", PointName(ptNumber), err)
+	}
+	return attrs, nil
+}
@@ -0,0 +1,207 @@
+package LibFTH
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Tuning steps of the automatic batch size
+const (
+	// tuneSamples batches are measured at each size before it is compared with the last one
+	tuneSamples = 3
+	// tuneGain is the throughput gain a size change has to bring to keep going in its direction
+	tuneGain = 1.05
+	// tuneErrorRate is the share of failed or retried values in a batch that makes the size shrink
+	tuneErrorRate = 0.01
+)
+
+// AutoBatch bounds the automatic batch size
+type AutoBatch struct {
+	Min int
+	Max int
+	// Latency is the longest a write call may take, larger batches shrink
+	Latency time.Duration
+}
+
+// batchStats are the measurements of the batches of one size
+type batchStats struct {
+	Batches int
+	Values  int
+	Errors  int
+	Push    time.Duration
+	Wait    time.Duration
+}
+
+func (s *batchStats) add(batch *WriteResult, errors int) {
+	s.Batches++
+	s.Values += batch.Sent
+	s.Errors += errors
+	s.Push += batch.Push
+	s.Wait += batch.Wait
+}
+
+func (s *batchStats) throughput() float64 {
+	if s.Push <= 0 {
+		return 0
+	}
+	return float64(s.Values) / s.Push.Seconds()
+}
+
+// tuner climbs towards the batch size with the best throughput. It doubles the size while that pays
+// off and halves it when doubling didn't help at the start. Slow or failing calls halve the size,
+// keep it below where they happened and look for a better size further down.
+type tuner struct {
+	mu      sync.Mutex
+	enabled bool
+	limits  AutoBatch
+	size    int
+	// prev is the size measured before the current one, 0 at the start
+	prev     int
+	grow     bool
+	reversed bool
+	settled  bool
+	curve    map[int]*batchStats
+	// window holds the measurements of the current size since it was chosen
+	window batchStats
+}
+
+var batchTuner tuner
+
+// SetAutoBatch makes the batch size follow the measured throughput, starting from the size set with
+// SetBatchSize. Like the other settings it must be called before the first write.
+func SetAutoBatch(limits AutoBatch) {
+	start := batchSize
+	if start < 1 {
+		start = limits.Max
+	}
+	batchTuner = tuner{enabled: true, limits: limits, size: min(max(start, limits.Min), limits.Max), grow: true, curve: make(map[int]*batchStats)}
+}
+
+// nextBatchSize is the size of the next batch, remaining is what is left of the file
+func nextBatchSize(remaining int) int {
+	if !batchTuner.enabled {
+		if batchSize < 1 {
+			return remaining
+		}
+		return batchSize
+	}
+	batchTuner.mu.Lock()
+	defer batchTuner.mu.Unlock()
+	return batchTuner.size
+}
+
+// observeBatch records a batch sent with the given size and moves the size when the measurements call for it
+func observeBatch(size int, batch *WriteResult) {
+	if !batchTuner.enabled || batch.Sent == 0 {
+		return
+	}
+	t := &batchTuner
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	errors := serverErrors(batch)
+	if t.curve[size] == nil {
+		t.curve[size] = &batchStats{}
+	}
+	t.curve[size].add(batch, errors)
+	// Only full batches of the current size tell about it, the last one of a file is usually shorter
+	if size != t.size || batch.Sent < size {
+		return
+	}
+	t.window.add(batch, errors)
+
+	if float64(errors) > float64(batch.Sent)*tuneErrorRate || (t.limits.Latency > 0 && batch.Push > t.limits.Latency) {
+		if t.size > t.limits.Min {
+			t.limits.Max = max(t.size/2, t.limits.Min)
+			// Smaller sizes are still measured, larger ones are not tried again
+			t.moveTo(t.limits.Max, false)
+			t.prev, t.settled = 0, false
+			slog.Info(fmt.Sprintf("Batch of %d values took %.2f seconds with %d errors, batch size lowered to %d",
+				batch.Sent, batch.Push.Seconds(), errors, t.size))
+		}
+		return
+	}
+	if t.settled || t.window.Batches < tuneSamples {
+		return
+	}
+
+	current := t.window.throughput()
+	previous := 0.0
+	if t.prev != 0 {
+		previous = t.curve[t.prev].throughput()
+	}
+	switch {
+	case t.prev == 0 || current > previous*tuneGain:
+		next := t.size * 2
+		if !t.grow {
+			next = t.size / 2
+		}
+		next = min(max(next, t.limits.Min), t.limits.Max)
+		if next == t.size {
+			t.settle(current)
+			return
+		}
+		t.moveTo(next, t.grow)
+	case t.grow && !t.reversed && t.prev*2 == t.size && t.curve[t.prev/2] == nil && t.prev/2 >= t.limits.Min:
+		// The first doubling didn't pay off, try smaller batches from the starting size
+		t.reversed = true
+		start := t.prev
+		t.moveTo(start/2, false)
+		t.prev = start
+	default:
+		best, bestSize := current, t.size
+		if previous > current {
+			best, bestSize = previous, t.prev
+		}
+		t.moveTo(bestSize, t.grow)
+		t.settle(best)
+	}
+}
+
+// serverErrors counts the values of a batch that failed or were retried for retryable errors. Values
+// rejected for their point or data, like a missing point or a denied write, fail at any batch size.
+func serverErrors(batch *WriteResult) int {
+	errors := batch.Retried
+	for key, f := range batch.Failures {
+		if errorClass(key.Code) == ErrorClassRetryable {
+			errors += f.Count
+		}
+	}
+	return errors
+}
+
+// moveTo switches to a new size and starts measuring it
+func (t *tuner) moveTo(size int, grow bool) {
+	t.prev, t.size, t.grow = t.size, size, grow
+	t.window = batchStats{}
+}
+
+func (t *tuner) settle(throughput float64) {
+	t.settled = true
+	slog.Info(fmt.Sprintf("Batch size settled at %d values, %.0f values per second", t.size, throughput))
+}
+
+// LogBatchTuning logs the batch size the run ended with and the throughput measured at every size
+func LogBatchTuning() {
+	if !batchTuner.enabled {
+		return
+	}
+	t := &batchTuner
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	slog.Info(fmt.Sprintf("Automatic batch size: ended at %d values", t.size))
+	sizes := make([]int, 0, len(t.curve))
+	for size := range t.curve {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	for _, size := range sizes {
+		s := t.curve[size]
+		slog.Info(fmt.Sprintf("Batch size %d: %d batches, %.0f values per second, %.3f seconds per call, %.3f seconds waiting, %d errors",
+			size, s.Batches, s.throughput(), s.Push.Seconds()/float64(s.Batches), s.Wait.Seconds()/float64(s.Batches), s.Errors))
+	}
+}
@@ -0,0 +1,106 @@
+package LibFTH
+
+import (
+	"testing"
+	"time"
+)
+
+// tuneStep is one batch sent at the current size, a short batch holds fewer values than the size
+type tuneStep struct {
+	short  bool
+	rate   float64
+	errors int
+	code   int32
+	repeat int
+}
+
+func TestObserveBatch(t *testing.T) {
+	fast := tuneStep{rate: 10000, repeat: 3}
+	tests := []struct {
+		name        string
+		limits      AutoBatch
+		start       int
+		steps       []tuneStep
+		wantSize    int
+		wantMax     int
+		wantSettled bool
+	}{
+		{"retried values shrink the size", AutoBatch{Min: 100, Max: 10000}, 1000,
+			[]tuneStep{{rate: 10000, errors: 20, repeat: 1}}, 500, 500, false},
+		{"retryable failures shrink the size", AutoBatch{Min: 100, Max: 10000}, 1000,
+			[]tuneStep{{rate: 10000, errors: 20, code: -10722, repeat: 1}}, 500, 500, false},
+		{"data failures don't shrink the size", AutoBatch{Min: 100, Max: 10000}, 1000,
+			[]tuneStep{{rate: 10000, errors: 20, code: -11049, repeat: 1}}, 1000, 10000, false},
+		{"point failures don't shrink the size", AutoBatch{Min: 100, Max: 10000}, 1000,
+			[]tuneStep{{rate: 10000, errors: 20, code: -10401, repeat: 1}}, 1000, 10000, false},
+		{"errors below the rate are tolerated", AutoBatch{Min: 100, Max: 10000}, 1000,
+			[]tuneStep{{rate: 10000, errors: 10, repeat: 1}}, 1000, 10000, false},
+		{"slow calls shrink the size", AutoBatch{Min: 100, Max: 10000, Latency: time.Second}, 1000,
+			[]tuneStep{{rate: 500, repeat: 1}}, 500, 500, false},
+		{"short batches are not measured", AutoBatch{Min: 100, Max: 10000, Latency: time.Second}, 1000,
+			[]tuneStep{{short: true, rate: 100, errors: 100, repeat: 5}}, 1000, 10000, false},
+		{"shrinking stops at the minimum", AutoBatch{Min: 400, Max: 10000}, 500,
+			[]tuneStep{{rate: 10000, errors: 20, repeat: 1}}, 400, 400, false},
+		{"errors at the minimum keep the size", AutoBatch{Min: 100, Max: 10000}, 100,
+			[]tuneStep{{rate: 10000, errors: 20, repeat: 3}}, 100, 10000, false},
+		{"repeated errors halve again", AutoBatch{Min: 100, Max: 10000}, 1000,
+			[]tuneStep{{rate: 10000, errors: 20, repeat: 2}}, 250, 250, false},
+		{"a size is measured before it grows", AutoBatch{Min: 100, Max: 10000}, 1000,
+			[]tuneStep{{rate: 10000, repeat: 2}}, 1000, 10000, false},
+		{"good throughput doubles the size", AutoBatch{Min: 100, Max: 10000}, 1000,
+			[]tuneStep{fast}, 2000, 10000, false},
+		{"growing stops at the maximum", AutoBatch{Min: 100, Max: 1500}, 1000,
+			[]tuneStep{fast, {rate: 20000, repeat: 3}}, 1500, 1500, true},
+		{"growing keeps on while it pays off", AutoBatch{Min: 100, Max: 10000}, 1000,
+			[]tuneStep{fast, {rate: 20000, repeat: 3}}, 4000, 10000, false},
+		{"growing settles on the better size", AutoBatch{Min: 100, Max: 10000}, 1000,
+			[]tuneStep{fast, {rate: 20000, repeat: 3}, {rate: 15000, repeat: 3}}, 2000, 10000, true},
+		{"a first doubling without gain tries smaller sizes", AutoBatch{Min: 100, Max: 10000}, 1000,
+			[]tuneStep{fast, fast}, 500, 10000, false},
+		{"smaller sizes without gain go back to the start", AutoBatch{Min: 100, Max: 10000}, 1000,
+			[]tuneStep{fast, fast, {rate: 8000, repeat: 3}}, 1000, 10000, true},
+		{"smaller sizes shrink while they pay off", AutoBatch{Min: 100, Max: 10000}, 1000,
+			[]tuneStep{fast, fast, {rate: 12000, repeat: 3}}, 250, 10000, false},
+		{"shrinking settles at the minimum", AutoBatch{Min: 500, Max: 10000}, 1000,
+			[]tuneStep{fast, fast, {rate: 12000, repeat: 3}}, 500, 10000, true},
+		{"errors after settling search again", AutoBatch{Min: 100, Max: 1500}, 1000,
+			[]tuneStep{fast, {rate: 20000, repeat: 3}, {rate: 20000, errors: 100, repeat: 1}}, 750, 750, false},
+	}
+	defer func() { batchTuner = tuner{} }()
+	for _, tt := range tests {
+		batchTuner = tuner{enabled: true, limits: tt.limits, size: tt.start, grow: true, curve: make(map[int]*batchStats)}
+		for _, step := range tt.steps {
+			for i := 0; i < step.repeat; i++ {
+				size := nextBatchSize(0)
+				observeBatch(size, step.batch(size))
+			}
+		}
+		if batchTuner.size != tt.wantSize || batchTuner.limits.Max != tt.wantMax || batchTuner.settled != tt.wantSettled {
+			t.Errorf("%s: size %d, max %d, settled %v, want %d, %d, %v", tt.name,
+				batchTuner.size, batchTuner.limits.Max, batchTuner.settled, tt.wantSize, tt.wantMax, tt.wantSettled)
+		}
+	}
+}
+
+// batch builds the result of a batch of the given size
+func (s tuneStep) batch(size int) *WriteResult {
+	r := NewWriteResult()
+	r.Batches = 1
+	r.Sent = size
+	if s.short {
+		r.Sent = size / 2
+	}
+	r.Push = time.Duration(float64(r.Sent) / s.rate * float64(time.Second))
+	switch {
+	case s.errors == 0:
+	case s.code == 0:
+		r.Retries = 1
+		r.Retried = s.errors
+	default:
+		for i := 0; i < s.errors; i++ {
+			r.AddFailure(1, s.code, time.Unix(int64(i), 0))
+		}
+	}
+	r.Succeeded = r.Sent - r.Failed
+	return r
+}
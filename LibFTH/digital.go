@@ -0,0 +1,109 @@
+package LibFTH
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// defaultStates is the key of the mapping used for digital points without their own mapping
+const defaultStates = "*"
+
+// DigitalStates maps datalog values to digital state offsets, per historian point name in upper case
+type DigitalStates map[string]map[float64]int32
+
+var digitalStates = DigitalStates{}
+
+// SetDigitalStates sets the value to state mapping used for digital points
+func SetDigitalStates(states DigitalStates) {
+	digitalStates = states
+}
+
+// LoadDigitalStatesCSV reads rows of historian point name, datalog value and digital state offset.
+// A point name of * applies to every digital point that has no rows of its own.
+func LoadDigitalStatesCSV(filePath string, states DigitalStates) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	for i := 0; ; i++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading CSV file at line %d: %w", i+1, err)
+		}
+		if len(record) < 3 {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Invalid digital value %q on row %d", record[1], i+1))
+			continue
+		}
+		state, err := strconv.ParseInt(strings.TrimSpace(record[2]), 10, 32)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Invalid digital state %q on row %d", record[2], i+1))
+			continue
+		}
+
+		name := strings.ToUpper(strings.TrimSpace(record[0]))
+		if states[name] == nil {
+			states[name] = make(map[float64]int32)
+		}
+		states[name][value] = int32(state)
+	}
+
+	return nil
+}
+
+// lookupState finds the state offset for a value of a digital point. Without a mapping, whole
+// non-negative values are used as the offset.
+func lookupState(piName string, v float64) (int32, bool) {
+	states, ok := digitalStates[strings.ToUpper(piName)]
+	if !ok {
+		states, ok = digitalStates[defaultStates]
+	}
+
+	if ok {
+		state, found := states[v]
+		return state, found
+	}
+	if v < 0 || v > math.MaxInt32 || v != math.Trunc(v) {
+		return 0, false
+	}
+	return int32(v), true
+}
+
+// ConvertValue fills the ival and istat arguments for a value written to a point of the given type.
+// ok is false when the value can't be represented by the point type.
+func ConvertValue(point *LibPI.PointCache, v float64) (ival int32, istat int32, ok bool) {
+	switch point.PIType {
+	case LibPI.PointTypeReal:
+		return 0, 0, true
+	case LibPI.PointTypeInteger:
+		if v < math.MinInt32 || v > math.MaxInt32 || v != math.Trunc(v) {
+			return 0, 0, false
+		}
+		return int32(v), 0, true
+	case LibPI.PointTypeDigital:
+		state, found := lookupState(point.PIName, v)
+		return 0, state, found
+	default:
+		return 0, 0, false
+	}
+}
@@ -0,0 +1,86 @@
+package LibFTH
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+func TestLoadDigitalStatesCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "digital_states.csv")
+	rows := "" +
+		"valve_1, 0, 0\n" +
+		"VALVE_1,1,2\n" +
+		"Valve_1,2.5,3\n" +
+		"*,1,1\n" +
+		"VALVE_1,on,1\n" +
+		"VALVE_1,3,closed\n" +
+		"VALVE_1,4,2147483648\n" +
+		"VALVE_1,5\n" +
+		"\n" +
+		"PUMP_2,0,-1\n"
+	if err := os.WriteFile(path, []byte(rows), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	states := DigitalStates{}
+	if err := LoadDigitalStatesCSV(path, states); err != nil {
+		t.Fatal(err)
+	}
+	want := DigitalStates{
+		"VALVE_1": {0: 0, 1: 2, 2.5: 3},
+		"*":       {1: 1},
+		"PUMP_2":  {0: -1},
+	}
+	if !reflect.DeepEqual(states, want) {
+		t.Errorf("loaded %v, want %v", states, want)
+	}
+
+	if err := LoadDigitalStatesCSV(filepath.Join(t.TempDir(), "missing.csv"), states); err == nil {
+		t.Error("loading a missing file did not fail")
+	}
+}
+
+func TestConvertValue(t *testing.T) {
+	defer SetDigitalStates(DigitalStates{})
+	id := int32(1)
+	point := func(name string, pointType LibPI.PointType) *LibPI.PointCache {
+		return &LibPI.PointCache{PIName: name, PIId: &id, PIType: pointType}
+	}
+	tests := []struct {
+		name   string
+		states DigitalStates
+		point  *LibPI.PointCache
+		v      float64
+		ival   int32
+		istat  int32
+		ok     bool
+	}{
+		{"real", nil, point("R", LibPI.PointTypeReal), 12.5, 0, 0, true},
+		{"integer", nil, point("I", LibPI.PointTypeInteger), -42, -42, 0, true},
+		{"integer with a fraction", nil, point("I", LibPI.PointTypeInteger), 1.5, 0, 0, false},
+		{"integer above the range", nil, point("I", LibPI.PointTypeInteger), 2147483648, 0, 0, false},
+		{"integer below the range", nil, point("I", LibPI.PointTypeInteger), -2147483649, 0, 0, false},
+		{"digital offset without a mapping", nil, point("D", LibPI.PointTypeDigital), 3, 0, 3, true},
+		{"digital largest offset", nil, point("D", LibPI.PointTypeDigital), 2147483647, 0, 2147483647, true},
+		{"digital offset above the range", nil, point("D", LibPI.PointTypeDigital), 2147483648, 0, 0, false},
+		{"digital negative offset", nil, point("D", LibPI.PointTypeDigital), -1, 0, 0, false},
+		{"digital offset with a fraction", nil, point("D", LibPI.PointTypeDigital), 0.5, 0, 0, false},
+		{"digital mapped", DigitalStates{"VALVE_1": {1: 2}}, point("Valve_1", LibPI.PointTypeDigital), 1, 0, 2, true},
+		{"digital value not in its mapping", DigitalStates{"VALVE_1": {1: 2}, "*": {3: 4}}, point("VALVE_1", LibPI.PointTypeDigital), 3, 0, 0, false},
+		{"digital default mapping", DigitalStates{"VALVE_1": {1: 2}, "*": {3: 4}}, point("PUMP_2", LibPI.PointTypeDigital), 3, 0, 4, true},
+		{"digital value not in the default mapping", DigitalStates{"*": {3: 4}}, point("PUMP_2", LibPI.PointTypeDigital), 1, 0, 0, false},
+		{"unknown point type", nil, point("U", LibPI.PointTypeUnknown), 1, 0, 0, false},
+		{"unlisted point type", nil, point("U", LibPI.PointType(99)), 1, 0, 0, false},
+	}
+	for _, tt := range tests {
+		SetDigitalStates(tt.states)
+		ival, istat, ok := ConvertValue(tt.point, tt.v)
+		if ival != tt.ival || istat != tt.istat || ok != tt.ok {
+			t.Errorf("%s: ConvertValue(%v) = %d, %d, %v, want %d, %d, %v", tt.name, tt.v, ival, istat, ok, tt.ival, tt.istat, tt.ok)
+		}
+	}
+}
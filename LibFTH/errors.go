@@ -0,0 +1,200 @@
+package LibFTH
+
+/*
+#include <stdint.h>
+
+extern int32_t piut_errormsg(int32_t stat, char* msg, int32_t* msglen);
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// ErrorClass sorts PI API errors by what a caller can do about them
+type ErrorClass int
+
+const (
+	ErrorClassUnknown ErrorClass = iota
+	// ErrorClassRetryable errors are about the connection or server load, the same call may succeed later
+	ErrorClassRetryable
+	// ErrorClassPoint errors are about the point itself, every value for it will fail until it is fixed
+	ErrorClassPoint
+	// ErrorClassData errors are about a single value, other values of the same point may be fine
+	ErrorClassData
+)
+
+// String provides a string representation of the ErrorClass
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorClassRetryable:
+		return "Retryable"
+	case ErrorClassPoint:
+		return "Point"
+	case ErrorClassData:
+		return "Data"
+	default:
+		return "Unknown"
+	}
+}
+
+// Sentinels for errors.Is, every PIError matches the one of its class
+var (
+	ErrRetryable  = errors.New("retryable historian error")
+	ErrPointLevel = errors.New("historian point error")
+	ErrDataLevel  = errors.New("historian data error")
+)
+
+// PIError is an error code returned by a piapi call
+type PIError struct {
+	Call        string
+	Code        int32
+	Name        string
+	Description string
+	Class       ErrorClass
+}
+
+func (e *PIError) Error() string {
+	if e.Call == "" {
+		return fmt.Sprintf("error %d %s: %s", e.Code, e.Name, e.Description)
+	}
+	return fmt.Sprintf("%s returned error %d %s: %s", e.Call, e.Code, e.Name, e.Description)
+}
+
+// Is matches the class sentinels and any other PIError with the same code
+func (e *PIError) Is(target error) bool {
+	switch target {
+	case ErrRetryable:
+		return e.Class == ErrorClassRetryable
+	case ErrPointLevel:
+		return e.Class == ErrorClassPoint
+	case ErrDataLevel:
+		return e.Class == ErrorClassData
+	}
+	var other *PIError
+	if errors.As(target, &other) {
+		return other.Code == e.Code
+	}
+	return false
+}
+
+// codeNotPIError records failures that were not a PI API error code, such as a failed Go call or
+// a lost connection. 0 is success in the PI API, so it is never a code of a failed call.
+const codeNotPIError int32 = 0
+
+// codeNoEvents is returned by the archive reads when the time range holds no events
+const codeNoEvents int32 = -11059
+
+type errorInfo struct {
+	name        string
+	description string
+	class       ErrorClass
+}
+
+// errorCatalog holds the codes commonly seen during bulk imports. It is only used for names and
+// classes, and for descriptions when piut_errormsg has nothing to say about a code.
+var errorCatalog = map[int32]errorInfo{
+	codeNotPIError: {"NotPIError", "Failed outside the PI API, see the logged error", ErrorClassUnknown},
+	-1:             {"PointNotFound", "Point does not exist", ErrorClassPoint},
+	-5:             {"TagNotFound", "Tag not found", ErrorClassPoint},
+	-109:           {"Ignored", "Value not taken by the snapshot, treated as already written", ErrorClassData},
+	-10400:         {"NoReadAccess", "No read access - secure object", ErrorClassPoint},
+	-10401:         {"NoWriteAccess", "No write access - secure object", ErrorClassPoint},
+	-10722:         {"Timeout", "PINET: timeout on PI RPC or system call", ErrorClassRetryable},
+	-10733:         {"NotConnected", "PINET: no connection to the server", ErrorClassRetryable},
+	-11046:         {"FutureTimestamp", "Target date in the future", ErrorClassData},
+	-11049:         {"DateNotOnline", "Date not on-line, no archive covers the timestamp", ErrorClassData},
+	codeNoEvents:   {"NoEvents", "No events found in the time range", ErrorClassData},
+}
+
+// classify falls back on the ranges the PI error codes are grouped in
+func classify(code int32) ErrorClass {
+	switch {
+	case code <= -10700 && code > -10800:
+		// PINET network errors
+		return ErrorClassRetryable
+	case code <= -10400 && code > -10500:
+		// Security errors
+		return ErrorClassPoint
+	case code <= -11000 && code > -11200:
+		// Archive errors
+		return ErrorClassData
+	default:
+		return ErrorClassUnknown
+	}
+}
+
+// errorClass classes a code without asking the DLL for its message
+func errorClass(code int32) ErrorClass {
+	if info, ok := errorCatalog[code]; ok {
+		return info.class
+	}
+	return classify(code)
+}
+
+// piErrorCode returns the code of the PIError wrapped in err, or codeNotPIError when there is none
+func piErrorCode(err error) int32 {
+	var piErr *PIError
+	if errors.As(err, &piErr) {
+		return piErr.Code
+	}
+	return codeNotPIError
+}
+
+var dllMessages sync.Map
+
+// errorMessageOnWorker asks piut_errormsg for the text of a code
+func errorMessageOnWorker(code int32) string {
+	if msg, ok := dllMessages.Load(code); ok {
+		return msg.(string)
+	}
+
+	buf := make([]byte, 256)
+	msgLen := C.int32_t(len(buf))
+	if C.piut_errormsg(C.int32_t(code), (*C.char)(unsafe.Pointer(&buf[0])), &msgLen) != 0 {
+		dllMessages.Store(code, "")
+		return ""
+	}
+	n := min(max(int(msgLen), 0), len(buf))
+	msg := strings.TrimSpace(strings.TrimRight(string(buf[:n]), "\x00"))
+	if i := strings.IndexByte(msg, 0); i >= 0 {
+		msg = msg[:i]
+	}
+	dllMessages.Store(code, msg)
+	return msg
+}
+
+// lookupError builds a PIError from the catalog and the optional DLL message
+func lookupError(call string, code int32, dllMessage string) *PIError {
+	info, ok := errorCatalog[code]
+	if !ok {
+		info = errorInfo{name: "Unknown", description: "no description available", class: classify(code)}
+	}
+	if dllMessage != "" {
+		info.description = dllMessage
+	}
+	return &PIError{Call: call, Code: code, Name: info.name, Description: info.description, Class: info.class}
+}
+
+// newPIErrorOnWorker builds a PIError for a failed call
+func newPIErrorOnWorker(call string, code int32) *PIError {
+	return lookupError(call, code, errorMessageOnWorker(code))
+}
+
+// LookupError describes an error code, using piut_errormsg when the DLL knows the code
+func LookupError(code int32) *PIError {
+	if code == codeNotPIError {
+		return lookupError("", code, "")
+	}
+	if msg, ok := dllMessages.Load(code); ok {
+		return lookupError("", code, msg.(string))
+	}
+	var piErr *PIError
+	do(requestLookup, func() {
+		piErr = newPIErrorOnWorker("", code)
+	})
+	return piErr
+}
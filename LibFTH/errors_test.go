@@ -0,0 +1,84 @@
+package LibFTH
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorClasses(t *testing.T) {
+	sentinels := map[ErrorClass]error{
+		ErrorClassRetryable: ErrRetryable,
+		ErrorClassPoint:     ErrPointLevel,
+		ErrorClassData:      ErrDataLevel,
+	}
+	tests := []struct {
+		code  int32
+		name  string
+		class ErrorClass
+	}{
+		// From the catalog
+		{-1, "PointNotFound", ErrorClassPoint},
+		{-5, "TagNotFound", ErrorClassPoint},
+		{-109, "Ignored", ErrorClassData},
+		{-10400, "NoReadAccess", ErrorClassPoint},
+		{-10401, "NoWriteAccess", ErrorClassPoint},
+		{-10722, "Timeout", ErrorClassRetryable},
+		{-10733, "NotConnected", ErrorClassRetryable},
+		{-11049, "DateNotOnline", ErrorClassData},
+		{codeNoEvents, "NoEvents", ErrorClassData},
+		{codeNotPIError, "NotPIError", ErrorClassUnknown},
+		// From the ranges
+		{-10700, "Unknown", ErrorClassRetryable},
+		{-10799, "Unknown", ErrorClassRetryable},
+		{-10499, "Unknown", ErrorClassPoint},
+		{-11000, "Unknown", ErrorClassData},
+		{-11199, "Unknown", ErrorClassData},
+		// Outside the ranges
+		{-10800, "Unknown", ErrorClassUnknown},
+		{-10500, "Unknown", ErrorClassUnknown},
+		{-11200, "Unknown", ErrorClassUnknown},
+		{-2, "Unknown", ErrorClassUnknown},
+		{-99999, "Unknown", ErrorClassUnknown},
+		{42, "Unknown", ErrorClassUnknown},
+	}
+	for _, tt := range tests {
+		if got := errorClass(tt.code); got != tt.class {
+			t.Errorf("errorClass(%d) = %s, want %s", tt.code, got, tt.class)
+		}
+		piErr := lookupError("pisn_putsnapshotsx", tt.code, "")
+		if piErr.Name != tt.name || piErr.Class != tt.class {
+			t.Errorf("lookupError(%d) is %s of class %s, want %s of class %s", tt.code, piErr.Name, piErr.Class, tt.name, tt.class)
+		}
+
+		// The class is still found through wrapping, and only the sentinel of the class matches
+		err := fmt.Errorf("writing file: %w", piErr)
+		for class, sentinel := range sentinels {
+			if got := errors.Is(err, sentinel); got != (class == tt.class) {
+				t.Errorf("errors.Is(error %d, %v) = %v, want %v", tt.code, sentinel, got, class == tt.class)
+			}
+		}
+		if got := piErrorCode(err); got != tt.code {
+			t.Errorf("piErrorCode of error %d = %d", tt.code, got)
+		}
+	}
+}
+
+func TestPIErrorIsCode(t *testing.T) {
+	err := fmt.Errorf("reading: %w", lookupError("piar_compvaluesx", -11049, "Date not on-line"))
+	if !errors.Is(err, &PIError{Code: -11049}) {
+		t.Error("the error does not match a PIError with the same code")
+	}
+	if errors.Is(err, &PIError{Code: -11046, Class: ErrorClassData}) {
+		t.Error("the error matches a PIError with another code of the same class")
+	}
+	if errors.Is(err, errors.New("Date not on-line")) {
+		t.Error("the error matches an error that is not a PIError")
+	}
+	if got := piErrorCode(errors.New("connection reset")); got != codeNotPIError {
+		t.Errorf("piErrorCode of a plain error = %d, want %d", got, codeNotPIError)
+	}
+	if got := lookupError("", -11049, "from the DLL").Description; got != "from the DLL" {
+		t.Errorf("the description is %q, want the DLL message", got)
+	}
+}
@@ -0,0 +1,79 @@
+package LibFTH
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+extern int32_t piut_login(const char* username, const char* passwd, int32_t* valid);
+*/
+import "C"
+import (
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+)
+
+// AccessLevel is the access piut_login grants to the user
+type AccessLevel int32
+
+const (
+	AccessNone AccessLevel = iota
+	AccessRead
+	AccessReadWrite
+)
+
+// String provides a string representation of the AccessLevel
+func (a AccessLevel) String() string {
+	switch a {
+	case AccessRead:
+		return "read"
+	case AccessReadWrite:
+		return "read/write"
+	default:
+		return "none"
+	}
+}
+
+// loginUser and loginPassword are set by SetLogin, without a user the connection relies on a trust
+var loginUser string
+var loginPassword string
+
+// loginAccess is the access level of the last login
+var loginAccess atomic.Int32
+
+// SetLogin makes Connect, and every reconnect, log in with piut_login instead of relying on a trust
+func SetLogin(user string, password string) {
+	loginUser = user
+	loginPassword = password
+}
+
+// LoginAccess returns the access level of the last login, false when no user is set
+func LoginAccess() (AccessLevel, bool) {
+	if loginUser == "" {
+		return AccessNone, false
+	}
+	return AccessLevel(loginAccess.Load()), true
+}
+
+// loginOnWorker logs in to the connected server when a user is set
+func loginOnWorker() error {
+	if loginUser == "" {
+		return nil
+	}
+
+	cUser := C.CString(loginUser)
+	defer C.free(unsafe.Pointer(cUser))
+	cPassword := C.CString(loginPassword)
+	defer C.free(unsafe.Pointer(cPassword))
+
+	var valid C.int32_t
+	if code := C.piut_login(cUser, cPassword, &valid); code != 0 {
+		loginAccess.Store(int32(AccessNone))
+		return fmt.Errorf("failed to log in as %s: %w", loginUser, newPIErrorOnWorker("piut_login", int32(code)))
+	}
+	loginAccess.Store(int32(valid))
+	if AccessLevel(valid) == AccessNone {
+		return fmt.Errorf("login as %s was accepted without any access", loginUser)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+#include <stdint.h>
+
+// PITIMESTAMP as declared in piapi.h, LibPI.PITIMESTAMP has the same layout
+struct PITIMESTAMP {
+    int32_t month;
+    int32_t year;
+    int32_t day;
+    int32_t hour;
+    int32_t minute;
+    int32_t tzinfo;
+    double second;
+};
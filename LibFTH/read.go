@@ -0,0 +1,126 @@
+package LibFTH
+
+/*
+#include "pitimestamp.h"
+
+extern int32_t pisn_getsnapshotx(int32_t ptnum, double* drval, int32_t* ival, void* bval, uint32_t* bsize,
+                                 int32_t* istat, int16_t* flags, struct PITIMESTAMP* timestamp);
+extern int32_t piar_compvaluesx(int32_t ptnum, int32_t* count, double* drval, int32_t* ival, void* bval, uint32_t* bsize,
+                                int32_t* istat, int16_t* flags, struct PITIMESTAMP* time0, struct PITIMESTAMP* time1, int32_t mode);
+*/
+import "C"
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// Iteration modes of the piar_*x calls
+const (
+	getFirst = 0
+	getNext  = 1
+)
+
+// ArchiveValue is an event read back from the archive
+type ArchiveValue struct {
+	Time  time.Time
+	Value float64
+	Ival  int32
+	Istat int32
+
+	// stamp is the timestamp as the DLL returned it
+	stamp LibPI.PITIMESTAMP
+}
+
+// CompValues reads the archive events of a point between start and end with piar_compvaluesx
+func CompValues(ptNumber int32, start time.Time, end time.Time) ([]ArchiveValue, error) {
+	var values []ArchiveValue
+	var err error
+	do(requestLookup, func() {
+		time0 := LibPI.NewPITIMESTAMP(start)
+		time1 := LibPI.NewPITIMESTAMP(end)
+		cTime0 := cTimestamp(&time0)
+		cTime1 := cTimestamp(&time1)
+
+		var count C.int32_t
+		var drval C.double
+		var ival, istat C.int32_t
+		var bsize C.uint32_t
+		var flags C.int16_t
+
+		mode := C.int32_t(getFirst)
+		for {
+			code := C.piar_compvaluesx(C.int32_t(ptNumber), &count, &drval, &ival, nil, &bsize, &istat, &flags, cTime0, cTime1, mode)
+			if code != 0 {
+				if mode == getFirst && int32(code) == codeNoEvents {
+					return
+				}
+				err = newPIErrorOnWorker("piar_compvaluesx", int32(code))
+				return
+			}
+			values = append(values, ArchiveValue{Time: time0.Time(), Value: float64(drval), Ival: int32(ival), Istat: int32(istat), stamp: time0})
+			if len(values) >= int(count) {
+				return
+			}
+			mode = getNext
+		}
+	})
+	return values, err
+}
+
+// skipExisting drops values the historian already holds, see SetSkipExisting
+var skipExisting bool
+
+// latestTimes keeps the first answer per point, later files of the run must not see the values
+// the run wrote itself
+var latestTimes sync.Map
+
+// SetSkipExisting makes AddToPIPointCache look up the newest time of each point, so values at or
+// before it are dropped instead of being written again
+func SetSkipExisting(skip bool) {
+	skipExisting = skip
+}
+
+// Snapshot reads the snapshot of a point with pisn_getsnapshotx, the newest event the historian holds
+func Snapshot(ptNumber int32) (ArchiveValue, error) {
+	var ts LibPI.PITIMESTAMP
+	var drval C.double
+	var ival, istat C.int32_t
+	var err error
+	do(requestLookup, func() {
+		var bsize C.uint32_t
+		var flags C.int16_t
+		cTs := cTimestamp(&ts)
+		if code := C.pisn_getsnapshotx(C.int32_t(ptNumber), &drval, &ival, nil, &bsize, &istat, &flags, cTs); code != 0 {
+			err = fmt.Errorf("error reading snapshot of point %d: %w", ptNumber, newPIErrorOnWorker("pisn_getsnapshotx", int32(code)))
+		}
+	})
+	if err != nil {
+		return ArchiveValue{}, err
+	}
+	return ArchiveValue{Time: ts.Time(), Value: float64(drval), Ival: int32(ival), Istat: int32(istat), stamp: ts}, nil
+}
+
+// SnapshotTime returns the time of the snapshot of a point
+func SnapshotTime(ptNumber int32) (time.Time, error) {
+	snapshot, err := Snapshot(ptNumber)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return snapshot.Time, nil
+}
+
+// latestTime returns the snapshot time of a point as it was the first time the run asked
+func latestTime(ptNumber int32) (time.Time, error) {
+	if latest, ok := latestTimes.Load(ptNumber); ok {
+		return latest.(time.Time), nil
+	}
+	latest, err := SnapshotTime(ptNumber)
+	if err != nil {
+		return time.Time{}, err
+	}
+	actual, _ := latestTimes.LoadOrStore(ptNumber, latest)
+	return actual.(time.Time), nil
+}
@@ -0,0 +1,171 @@
+package LibFTH
+
+/*
+#include <stdint.h>
+
+extern int32_t piut_disconnect();
+extern int32_t pitm_servertime(int32_t* servertime);
+*/
+import "C"
+import (
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// maxBackoff caps the wait between reconnect attempts
+const maxBackoff = 2 * time.Minute
+
+// RetryPolicy bounds how batches that failed with retryable errors are sent again
+type RetryPolicy struct {
+	// Attempts is how often one batch is retried before its values are counted as failed
+	Attempts int
+	// Budget is how many batch retries the whole run may use
+	Budget int
+	// Backoff is the wait before the first reconnect, it doubles with every attempt
+	Backoff time.Duration
+}
+
+var retryPolicy = RetryPolicy{Attempts: 5, Budget: 100, Backoff: time.Second}
+var retriesUsed atomic.Int64
+
+// serverName is the node passed to Connect, reconnects go to the same server
+var serverName string
+
+// SetRetryPolicy sets how failed batches are retried
+func SetRetryPolicy(policy RetryPolicy) {
+	retryPolicy = policy
+}
+
+// takeRetry uses one retry of the run budget for the given attempt of a batch
+func takeRetry(attempt int) bool {
+	if attempt > retryPolicy.Attempts {
+		return false
+	}
+	if retriesUsed.Add(1) > int64(retryPolicy.Budget) {
+		retriesUsed.Add(-1)
+		return false
+	}
+	return true
+}
+
+func backoff(attempt int) time.Duration {
+	wait := retryPolicy.Backoff
+	for i := 1; i < attempt && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxBackoff)
+}
+
+// CheckConnection asks the server for its time to confirm the connection still works
+func CheckConnection() error {
+	var err error
+	do(requestAdmin, func() {
+		err = checkConnectionOnWorker()
+	})
+	return err
+}
+
+func checkConnectionOnWorker() error {
+	var serverTime C.int32_t
+	if code := C.pitm_servertime(&serverTime); code != 0 {
+		return newPIErrorOnWorker("pitm_servertime", int32(code))
+	}
+	return nil
+}
+
+// reconnect waits out the backoff of the attempt and connects again when the connection is down.
+// The check and the reconnect run as one request, so when several files hit the same outage
+// only the first one reconnects.
+func reconnect(attempt int) error {
+	time.Sleep(backoff(attempt))
+
+	var err error
+	do(requestAdmin, func() {
+		if checkConnectionOnWorker() == nil {
+			return
+		}
+		slog.Warn(fmt.Sprintf("Historian connection lost, reconnecting to %s (attempt %d)", serverName, attempt))
+		C.piut_disconnect()
+		if err = connectOnWorker(serverName); err == nil {
+			slog.Info(fmt.Sprintf("Reconnected to %s", serverName))
+		}
+	})
+	return err
+}
+
+// retryItem is a value of a batch that failed with a retryable error
+type retryItem struct {
+	index int
+	code  int32
+}
+
+// putBatch sends one batch with the current write mode, once the throttle lets it
+func putBatch(mode WriteMode, ptids []int32, vs []float64, ivals []int32, istats []int32, ts []LibPI.PITIMESTAMP) (*WriteResult, error) {
+	held := acquireWrite(len(ptids))
+	var result *WriteResult
+	var err error
+	if mode == WriteModeSnapshot {
+		result, err = PutSnapshots(int32(len(ptids)), ptids, vs, ivals, istats, ts)
+	} else {
+		result, err = PutArchiveValues(int32(len(ptids)), mode, ptids, vs, ivals, istats, ts)
+	}
+	releaseWrite(len(ptids), result.Push)
+	result.Held += held
+	return result, err
+}
+
+// putBatchWithRetry sends one batch and sends the values that failed with retryable errors again
+// after reconnecting, until they are written or the attempts or the run budget are used up.
+func putBatchWithRetry(mode WriteMode, ptids []int32, vs []float64, ivals []int32, istats []int32, ts []LibPI.PITIMESTAMP) (*WriteResult, error) {
+	result, err := putBatch(mode, ptids, vs, ivals, istats, ts)
+
+	for attempt := 1; len(result.retry) > 0; attempt++ {
+		pending := result.retry
+		result.retry = nil
+
+		if !takeRetry(attempt) {
+			for _, item := range pending {
+				result.AddFailure(ptids[item.index], item.code, ts[item.index].Time())
+			}
+			break
+		}
+
+		slog.Warn(fmt.Sprintf("Retrying %d values after error %d (attempt %d)", len(pending), pending[0].code, attempt))
+		if rerr := reconnect(attempt); rerr != nil {
+			slog.Error(rerr.Error())
+		}
+
+		rPtids := make([]int32, len(pending))
+		rVs := make([]float64, len(pending))
+		rIvals := make([]int32, len(pending))
+		rIstats := make([]int32, len(pending))
+		rTs := make([]LibPI.PITIMESTAMP, len(pending))
+		for i, item := range pending {
+			rPtids[i] = ptids[item.index]
+			rVs[i] = vs[item.index]
+			rIvals[i] = ivals[item.index]
+			rIstats[i] = istats[item.index]
+			rTs[i] = ts[item.index]
+		}
+
+		var again *WriteResult
+		again, err = putBatch(mode, rPtids, rVs, rIvals, rIstats, rTs)
+		result.Retries++
+		result.Retried += len(pending)
+
+		// Values still pending point back into the original batch
+		for _, item := range again.retry {
+			result.retry = append(result.retry, retryItem{index: pending[item.index].index, code: item.code})
+		}
+		again.retry = nil
+		again.Batches = 0
+		again.Sent = 0
+		result.Merge(again)
+	}
+
+	return result, err
+}
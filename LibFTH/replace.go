@@ -0,0 +1,141 @@
+package LibFTH
+
+/*
+#include "pitimestamp.h"
+
+extern int32_t piar_putarcvaluesx(int32_t count, int32_t mode, int32_t* frombuf, int32_t* ptnum, double* drval, int32_t* ival,
+                                  uint8_t* bval, uint32_t* bsize, int32_t* istat, int16_t* flags, struct PITIMESTAMP* timestamp, int32_t* errors);
+*/
+import "C"
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// arcDelete is the piar_putarcvaluesx mode that removes the event at each timestamp
+const arcDelete = 7
+
+// replaceExisting and replaceDryRun are set by SetReplace
+var replaceExisting bool
+var replaceDryRun bool
+
+// SetReplace makes every file delete the archive events of each point in the time range of its
+// values before they are written. With dryRun the events are only counted and nothing is written.
+func SetReplace(enabled bool, dryRun bool) {
+	replaceExisting = enabled
+	replaceDryRun = dryRun
+}
+
+// DeleteArchiveValues removes archive events of a point, as read by CompValues, with piar_putarcvaluesx.
+// It returns how many were deleted, the error wraps the PIError of the first event that was not.
+func DeleteArchiveValues(ptNumber int32, events []ArchiveValue) (int, error) {
+	size := batchSize
+	if size < 1 {
+		size = len(events)
+	}
+
+	deleted := 0
+	var failed []int32
+	for i := 0; i < len(events); i += size {
+		end := min(i+size, len(events))
+		count := end - i
+		ptids := make([]C.int32_t, count)
+		vs := make([]C.double, count)
+		ivals := make([]C.int32_t, count)
+		bsizes := make([]C.uint32_t, count)
+		istats := make([]C.int32_t, count)
+		flags := make([]C.int16_t, count)
+		errors := make([]C.int32_t, count)
+		ts := make([]LibPI.PITIMESTAMP, count)
+		for j := range ts {
+			ptids[j] = C.int32_t(ptNumber)
+			// The timestamps are passed back as read, so they match the stored events exactly
+			ts[j] = events[i+j].stamp
+		}
+		cTs := cTimestamps(ts)
+
+		do(requestWrite, func() {
+			var frombuf C.int32_t
+			code := C.piar_putarcvaluesx(C.int32_t(count), arcDelete, &frombuf, &ptids[0], &vs[0], &ivals[0], nil, &bsizes[0], &istats[0], &flags[0], cTs, &errors[0])
+			if code == 0 {
+				deleted += count
+				return
+			}
+			itemErrors := false
+			for _, e := range errors {
+				if e == 0 {
+					deleted++
+					continue
+				}
+				itemErrors = true
+				failed = append(failed, int32(e))
+			}
+			if !itemErrors {
+				for range errors {
+					failed = append(failed, int32(code))
+				}
+			}
+		})
+	}
+
+	if len(failed) > 0 {
+		return deleted, fmt.Errorf("%d of %d archive events of %s could not be deleted: %w",
+			len(failed), len(events), PointName(ptNumber), LookupError(failed[0]))
+	}
+	return deleted, nil
+}
+
+// replaceWindows deletes, or with a dry run counts, the archive events of every point between the
+// first and last of its values. It returns the points whose events could not be deleted with the
+// error code, their values must not be written over the old events.
+func replaceWindows(ptids []int32, ts []LibPI.PITIMESTAMP, result *WriteResult) map[int32]int32 {
+	type window struct{ first, last time.Time }
+	windows := make(map[int32]*window)
+	var order []int32
+	for i, id := range ptids {
+		t := ts[i].Time()
+		w, ok := windows[id]
+		if !ok {
+			windows[id] = &window{first: t, last: t}
+			order = append(order, id)
+			continue
+		}
+		if t.Before(w.first) {
+			w.first = t
+		}
+		if t.After(w.last) {
+			w.last = t
+		}
+	}
+
+	blocked := make(map[int32]int32)
+	for _, id := range order {
+		w := windows[id]
+		events, err := CompValues(id, w.first, w.last)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error reading archive of %s before replacing it: %v", PointName(id), err))
+			blocked[id] = piErrorCode(err)
+			continue
+		}
+		if replaceDryRun {
+			result.Previewed += len(events)
+			slog.Debug(fmt.Sprintf("%s: %d archive events between %s and %s would be deleted", PointName(id), len(events),
+				w.first.Format("2006-01-02 15:04:05.000"), w.last.Format("2006-01-02 15:04:05.000")))
+			continue
+		}
+		if len(events) == 0 {
+			continue
+		}
+
+		deleted, err := DeleteArchiveValues(id, events)
+		result.Deleted += deleted
+		if err != nil {
+			slog.Error(err.Error())
+			blocked[id] = piErrorCode(err)
+		}
+	}
+	return blocked
+}
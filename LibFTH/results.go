@@ -0,0 +1,319 @@
+package LibFTH
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// maxFailureSamples is how many timestamps are kept for each point and error code
+const maxFailureSamples = 5
+
+// FailureKey groups rejected values by point and PI error code
+type FailureKey struct {
+	PointID int32
+	Code    int32
+	// Name tells apart points written without a point ID, such as through PI Web API
+	Name string
+}
+
+// PointFailure counts the values of one point that were rejected with one error code
+type PointFailure struct {
+	PointID int32
+	PIName  string
+	Code    int32
+	Count   int
+	First   time.Time
+	Last    time.Time
+	Samples []time.Time
+	// Times holds every rejected timestamp, for journals of the values to send again. Run totals don't keep them.
+	Times []time.Time
+}
+
+// Mismatch counts the values of one point that could not be converted to the type of the point
+type Mismatch struct {
+	PointID int32
+	PIName  string
+	Type    LibPI.PointType
+	Count   int
+	First   time.Time
+	Last    time.Time
+	Samples []float64
+}
+
+// WriteResult accounts for every value sent to the historian, for a batch, a file or a whole run
+type WriteResult struct {
+	Batches   int
+	Sent      int
+	Succeeded int
+	// Ignored values were answered with -109, they are not counted as failures
+	Ignored int
+	Failed  int
+	// Mismatched values don't fit the type of their point, they are not sent
+	Mismatched int
+	// Skipped values were at or before the newest time the historian held, they are not sent
+	Skipped int
+	// Deleted archive events were removed by -replace before writing, Previewed would have been in a dry run
+	Deleted   int
+	Previewed int
+	// Retries counts the batches sent again after retryable errors, Retried the values in them
+	Retries int
+	Retried int
+	Wait    time.Duration
+	Push    time.Duration
+	// Held is how long the write throttle held batches back
+	Held       time.Duration
+	Failures   map[FailureKey]*PointFailure
+	Mismatches map[int32]*Mismatch
+
+	// retry holds the values of a batch that failed with retryable errors and were not counted yet
+	retry []retryItem
+	// totals results add up a whole run and drop the Times of their failures
+	totals bool
+}
+
+func NewWriteResult() *WriteResult {
+	return &WriteResult{Failures: make(map[FailureKey]*PointFailure), Mismatches: make(map[int32]*Mismatch)}
+}
+
+// NewRunResult returns a result for the totals of a run, which counts failures without keeping every timestamp
+func NewRunResult() *WriteResult {
+	r := NewWriteResult()
+	r.totals = true
+	return r
+}
+
+// AddMismatch records a value that could not be converted to the type of its point
+func (r *WriteResult) AddMismatch(point *LibPI.PointCache, v float64, ts time.Time) {
+	r.Mismatched++
+	m, ok := r.Mismatches[*point.PIId]
+	if !ok {
+		m = &Mismatch{PointID: *point.PIId, PIName: point.PIName, Type: point.PIType, First: ts, Last: ts}
+		r.Mismatches[*point.PIId] = m
+	}
+	m.Count++
+	if ts.Before(m.First) {
+		m.First = ts
+	}
+	if ts.After(m.Last) {
+		m.Last = ts
+	}
+	if len(m.Samples) < maxFailureSamples {
+		m.Samples = append(m.Samples, v)
+	}
+}
+
+// AddFailure records a value of a point that the historian rejected
+func (r *WriteResult) AddFailure(pointID int32, code int32, ts time.Time) {
+	r.addFailure(FailureKey{PointID: pointID, Code: code}, PointName(pointID), ts)
+}
+
+// AddNamedFailure records a rejected value of a point known by its name only
+func (r *WriteResult) AddNamedFailure(name string, code int32, ts time.Time) {
+	r.addFailure(FailureKey{Code: code, Name: name}, name, ts)
+}
+
+func (r *WriteResult) addFailure(key FailureKey, name string, ts time.Time) {
+	r.Failed++
+	f, ok := r.Failures[key]
+	if !ok {
+		f = &PointFailure{PointID: key.PointID, PIName: name, Code: key.Code, First: ts, Last: ts}
+		r.Failures[key] = f
+	}
+	f.Count++
+	if ts.Before(f.First) {
+		f.First = ts
+	}
+	if ts.After(f.Last) {
+		f.Last = ts
+	}
+	if len(f.Samples) < maxFailureSamples {
+		f.Samples = append(f.Samples, ts)
+	}
+	if !r.totals {
+		f.Times = append(f.Times, ts)
+	}
+}
+
+// Merge adds the counts of other into r
+func (r *WriteResult) Merge(other *WriteResult) {
+	r.Batches += other.Batches
+	r.Sent += other.Sent
+	r.Succeeded += other.Succeeded
+	r.Ignored += other.Ignored
+	r.Failed += other.Failed
+	r.Mismatched += other.Mismatched
+	r.Skipped += other.Skipped
+	r.Deleted += other.Deleted
+	r.Previewed += other.Previewed
+	r.Retries += other.Retries
+	r.Retried += other.Retried
+	r.Wait += other.Wait
+	r.Push += other.Push
+	r.Held += other.Held
+
+	for key, of := range other.Failures {
+		f, ok := r.Failures[key]
+		if !ok {
+			copied := *of
+			copied.Samples = append([]time.Time(nil), of.Samples...)
+			copied.Times = nil
+			if !r.totals {
+				copied.Times = append([]time.Time(nil), of.Times...)
+			}
+			r.Failures[key] = &copied
+			continue
+		}
+		if !r.totals {
+			f.Times = append(f.Times, of.Times...)
+		}
+		f.Count += of.Count
+		if of.First.Before(f.First) {
+			f.First = of.First
+		}
+		if of.Last.After(f.Last) {
+			f.Last = of.Last
+		}
+		for _, ts := range of.Samples {
+			if len(f.Samples) >= maxFailureSamples {
+				break
+			}
+			f.Samples = append(f.Samples, ts)
+		}
+	}
+
+	for id, om := range other.Mismatches {
+		m, ok := r.Mismatches[id]
+		if !ok {
+			copied := *om
+			copied.Samples = append([]float64(nil), om.Samples...)
+			r.Mismatches[id] = &copied
+			continue
+		}
+		m.Count += om.Count
+		if om.First.Before(m.First) {
+			m.First = om.First
+		}
+		if om.Last.After(m.Last) {
+			m.Last = om.Last
+		}
+		for _, v := range om.Samples {
+			if len(m.Samples) >= maxFailureSamples {
+				break
+			}
+			m.Samples = append(m.Samples, v)
+		}
+	}
+}
+
+// SortedFailures returns the failure groups with the most rejected values first
+func (r *WriteResult) SortedFailures() []*PointFailure {
+	failures := make([]*PointFailure, 0, len(r.Failures))
+	for _, f := range r.Failures {
+		failures = append(failures, f)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		if failures[i].Count != failures[j].Count {
+			return failures[i].Count > failures[j].Count
+		}
+		if failures[i].PIName != failures[j].PIName {
+			return failures[i].PIName < failures[j].PIName
+		}
+		return failures[i].Code < failures[j].Code
+	})
+	return failures
+}
+
+// Log prints the counts of the result and every group of rejected values
+func (r *WriteResult) Log(scope string) {
+	slog.Info(fmt.Sprintf("%s: sent %d values in %d batches, %d written, %d ignored, %d failed, %d not converted, pushing %.2f seconds, waited %.2f seconds",
+		scope, r.Sent, r.Batches, r.Succeeded, r.Ignored, r.Failed, r.Mismatched, r.Push.Seconds(), r.Wait.Seconds()))
+	if r.Deleted > 0 {
+		slog.Warn(fmt.Sprintf("%s: %d existing archive events deleted before writing", scope, r.Deleted))
+	}
+	if r.Previewed > 0 {
+		slog.Info(fmt.Sprintf("%s: dry run, %d existing archive events would be deleted", scope, r.Previewed))
+	}
+	if r.Skipped > 0 {
+		slog.Info(fmt.Sprintf("%s: %d values skipped, the historian already held values up to their time", scope, r.Skipped))
+	}
+	if r.Held > 0 {
+		slog.Info(fmt.Sprintf("%s: held back %.2f seconds by the write throttle", scope, r.Held.Seconds()))
+	}
+	if r.Retries > 0 {
+		slog.Warn(fmt.Sprintf("%s: %d batch retries after retryable errors resent %d values", scope, r.Retries, r.Retried))
+	}
+
+	mismatches := make([]*Mismatch, 0, len(r.Mismatches))
+	for _, m := range r.Mismatches {
+		mismatches = append(mismatches, m)
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].PIName < mismatches[j].PIName })
+	for _, m := range mismatches {
+		samples := make([]string, len(m.Samples))
+		for i, v := range m.Samples {
+			samples[i] = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		slog.Error(fmt.Sprintf("%s: %d values of %s (point %d) could not be converted to a %s value between %s and %s, samples %s",
+			scope, m.Count, m.PIName, m.PointID, m.Type, m.First.Format("2006-01-02 15:04:05.000"),
+			m.Last.Format("2006-01-02 15:04:05.000"), strings.Join(samples, ", ")))
+	}
+
+	for _, f := range r.SortedFailures() {
+		samples := make([]string, len(f.Samples))
+		for i, ts := range f.Samples {
+			samples[i] = ts.Format("2006-01-02 15:04:05.000")
+		}
+		piErr := LookupError(f.Code)
+		// Points written by name have no point ID
+		point := fmt.Sprintf("%s (point %d)", f.PIName, f.PointID)
+		if f.PointID == 0 {
+			point = f.PIName
+		}
+		slog.Error(fmt.Sprintf("%s: %d values of %s rejected with error %d %s (%s, %s) between %s and %s, samples %s",
+			scope, f.Count, point, f.Code, piErr.Name, piErr.Description, piErr.Class,
+			f.First.Format("2006-01-02 15:04:05.000"), f.Last.Format("2006-01-02 15:04:05.000"),
+			strings.Join(samples, ", ")))
+	}
+}
+
+// Err summarizes the failed values as an error, or returns nil when every value was accepted.
+// The error wraps a PIError for every distinct code, so errors.Is works on the classes.
+func (r *WriteResult) Err() error {
+	var mismatchErr error
+	if r.Mismatched > 0 {
+		mismatchErr = fmt.Errorf("%d values across %d points could not be converted to the point type", r.Mismatched, len(r.Mismatches))
+	}
+	if r.Failed == 0 {
+		return mismatchErr
+	}
+	codes := make(map[int32]struct{})
+	var causes []error
+	for key := range r.Failures {
+		if _, seen := codes[key.Code]; seen {
+			continue
+		}
+		codes[key.Code] = struct{}{}
+		causes = append(causes, LookupError(key.Code))
+	}
+	failedErr := fmt.Errorf("%d of %d values rejected by the historian across %d points: %w",
+		r.Failed, r.Sent, r.failedPoints(), errors.Join(causes...))
+	if mismatchErr != nil {
+		return errors.Join(failedErr, mismatchErr)
+	}
+	return failedErr
+}
+
+func (r *WriteResult) failedPoints() int {
+	points := make(map[FailureKey]struct{})
+	for key := range r.Failures {
+		points[FailureKey{PointID: key.PointID, Name: key.Name}] = struct{}{}
+	}
+	return len(points)
+}
@@ -0,0 +1,82 @@
+package LibFTH
+
+import (
+	"testing"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+func TestMerge(t *testing.T) {
+	t0 := time.Date(2024, time.January, 2, 10, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return t0.Add(time.Duration(seconds) * time.Second) }
+	id := int32(7)
+	point := &LibPI.PointCache{PIName: "VALVE_1", PIId: &id, PIType: LibPI.PointTypeDigital}
+
+	batch := func(first int, failures int) *WriteResult {
+		r := NewWriteResult()
+		r.Batches, r.Sent, r.Succeeded, r.Ignored = 1, 10, 10-failures, 1
+		r.Skipped, r.Deleted, r.Previewed, r.Retries, r.Retried = 2, 3, 4, 1, 5
+		r.Wait, r.Push, r.Held = time.Second, 2*time.Second, 3*time.Second
+		for i := 0; i < failures; i++ {
+			r.AddFailure(id, -11049, at(first+i))
+		}
+		r.AddNamedFailure("WEB_POINT", -11046, at(first))
+		r.AddMismatch(point, 0.5, at(first))
+		return r
+	}
+
+	for _, totals := range []bool{false, true} {
+		run := NewWriteResult()
+		if totals {
+			run = NewRunResult()
+		}
+		first, second := batch(10, 4), batch(0, 3)
+		run.Merge(first)
+		run.Merge(second)
+
+		got := []int{run.Batches, run.Sent, run.Succeeded, run.Ignored, run.Failed, run.Mismatched,
+			run.Skipped, run.Deleted, run.Previewed, run.Retries, run.Retried}
+		want := []int{2, 20, 13, 2, 9, 2, 4, 6, 8, 2, 10}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("totals %v: counts %v, want %v", totals, got, want)
+				break
+			}
+		}
+		if run.Wait != 2*time.Second || run.Push != 4*time.Second || run.Held != 6*time.Second {
+			t.Errorf("totals %v: durations %s, %s, %s, want 2s, 4s, 6s", totals, run.Wait, run.Push, run.Held)
+		}
+
+		f := run.Failures[FailureKey{PointID: id, Code: -11049}]
+		if f == nil || f.Count != 7 || !f.First.Equal(at(0)) || !f.Last.Equal(at(13)) {
+			t.Fatalf("totals %v: failure %+v, want 7 values from 0s to 13s", totals, f)
+		}
+		if len(f.Samples) != maxFailureSamples {
+			t.Errorf("totals %v: %d samples, want %d", totals, len(f.Samples), maxFailureSamples)
+		}
+		wantTimes := 7
+		if totals {
+			wantTimes = 0
+		}
+		if len(f.Times) != wantTimes {
+			t.Errorf("totals %v: %d times kept, want %d", totals, len(f.Times), wantTimes)
+		}
+		if named := run.Failures[FailureKey{Code: -11046, Name: "WEB_POINT"}]; named == nil || named.Count != 2 {
+			t.Errorf("totals %v: failure of the named point %+v, want 2 values", totals, named)
+		}
+		m := run.Mismatches[id]
+		if m == nil || m.Count != 2 || !m.First.Equal(at(0)) || !m.Last.Equal(at(10)) || len(m.Samples) != 2 {
+			t.Errorf("totals %v: mismatch %+v, want 2 values from 0s to 10s", totals, m)
+		}
+
+		// The merged groups are copies, merging again does not change the batches
+		run.Merge(batch(20, 1))
+		if ff := first.Failures[FailureKey{PointID: id, Code: -11049}]; ff.Count != 4 || len(ff.Times) != 4 || len(ff.Samples) != 4 {
+			t.Errorf("totals %v: merging changed the failure of a batch to %+v", totals, ff)
+		}
+		if fm := first.Mismatches[id]; fm.Count != 1 || len(fm.Samples) != 1 {
+			t.Errorf("totals %v: merging changed the mismatch of a batch to %+v", totals, fm)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package LibFTH
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+extern int32_t pipt_wildcardsearch(char* tagmask, int32_t direction, int32_t* found, char* tagname, int32_t len,
+                                   int32_t* pt, int32_t* numfound);
+extern int32_t pipt_instrumenttag(int32_t ptnum, char* instrumenttag, int32_t len);
+extern int32_t pipt_exdesc(int32_t ptnum, char* exdesc, int32_t len);
+extern int32_t pipt_descriptor(int32_t ptnum, char* descriptor, int32_t len);
+*/
+import "C"
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// PointInfo is a historian point with the attributes that tell which datalog tag it holds
+type PointInfo struct {
+	Name          string
+	ID            int32
+	InstrumentTag string
+	ExDesc        string
+	Descriptor    string
+}
+
+// SearchPoints lists the points whose names match mask, with * and ? as wildcards, using pipt_wildcardsearch
+func SearchPoints(mask string) ([]PointInfo, error) {
+	cMask := C.CString(mask)
+	defer C.free(unsafe.Pointer(cMask))
+
+	var points []PointInfo
+	direction := C.int32_t(getFirst)
+	for {
+		var info PointInfo
+		var found, numFound, pt C.int32_t
+		var err error
+		do(requestLookup, func() {
+			buf := make([]byte, 256)
+			code := C.pipt_wildcardsearch(cMask, direction, &found, (*C.char)(unsafe.Pointer(&buf[0])), C.int32_t(len(buf)), &pt, &numFound)
+			if code != 0 {
+				err = newPIErrorOnWorker("pipt_wildcardsearch", int32(code))
+				return
+			}
+			if found == 0 {
+				return
+			}
+			info = PointInfo{Name: cString(buf), ID: int32(pt)}
+			err = pointTextsOnWorker(&info)
+		})
+		if err != nil {
+			return points, fmt.Errorf("error searching points matching %s: %w", mask, err)
+		}
+		if found == 0 {
+			return points, nil
+		}
+		points = append(points, info)
+		direction = getNext
+	}
+}
+
+// pointTextsOnWorker reads the instrument tag, extended descriptor and descriptor of a point
+func pointTextsOnWorker(info *PointInfo) error {
+	pt := C.int32_t(info.ID)
+	buf := make([]byte, 1024)
+	cBuf := (*C.char)(unsafe.Pointer(&buf[0]))
+
+	if code := C.pipt_instrumenttag(pt, cBuf, C.int32_t(len(buf))); code != 0 {
+		return newPIErrorOnWorker("pipt_instrumenttag", int32(code))
+	}
+	info.InstrumentTag = cString(buf)
+	if code := C.pipt_exdesc(pt, cBuf, C.int32_t(len(buf))); code != 0 {
+		return newPIErrorOnWorker("pipt_exdesc", int32(code))
+	}
+	info.ExDesc = cString(buf)
+	if code := C.pipt_descriptor(pt, cBuf, C.int32_t(len(buf))); code != 0 {
+		return newPIErrorOnWorker("pipt_descriptor", int32(code))
+	}
+	info.Descriptor = cString(buf)
+	return nil
+}
+
+// cString reads a string the DLL wrote into buf, up to its terminating zero
+func cString(buf []byte) string {
+	s, _, _ := strings.Cut(string(buf), "\x00")
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,268 @@
+package LibFTH
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minRateFactor is the furthest the adaptive mode slows the write rate down
+const minRateFactor = 1.0 / 64
+
+// Throttle limits how fast values are written, so an import doesn't hold up live data collection
+type Throttle struct {
+	// EventsPerSecond is the highest write rate, 0 for no limit
+	EventsPerSecond float64
+	// InFlight is how many worker processes may be in a write call at once, 0 for no limit. Within one
+	// process the DLL calls already run one at a time, so it only has an effect with worker processes.
+	InFlight int
+	// LatencyThreshold turns on the adaptive mode: when a write call takes longer, the rate is halved,
+	// and it recovers while calls stay well below it
+	LatencyThreshold time.Duration
+	// Windows are the times writes are allowed, writing pauses outside of them. Empty allows any time.
+	Windows []WriteWindow
+}
+
+// WriteWindow is a daily time range writes are allowed in, on the given weekdays or every day
+type WriteWindow struct {
+	Days  []time.Weekday
+	Start time.Duration
+	End   time.Duration
+}
+
+var throttle Throttle
+
+// throttleState is the pacing of the run, shared by all files
+var throttleState struct {
+	mu sync.Mutex
+	// next is when the rate allows the next batch to start
+	next time.Time
+	// rate is the current adaptive rate, 0 while the adaptive mode doesn't limit
+	rate float64
+	// floor is the slowest the adaptive rate goes, set when it starts slowing down
+	floor float64
+}
+
+// SetThrottle sets the write limits of the run. Like the other settings it must be called before the first write.
+func SetThrottle(t Throttle) {
+	throttle = t
+}
+
+// GetThrottle returns the write limits of the run
+func GetThrottle() Throttle {
+	return throttle
+}
+
+// acquireWrite waits until a batch of count values may be written and returns how long it waited.
+// releaseWrite must be called when the write call returns.
+func acquireWrite(count int) time.Duration {
+	rate := currentRate()
+	if rate == 0 && len(throttle.Windows) == 0 {
+		return 0
+	}
+
+	start := time.Now()
+	waitForWindow()
+	if rate > 0 {
+		throttleState.mu.Lock()
+		now := time.Now()
+		at := throttleState.next
+		if at.Before(now) {
+			at = now
+		}
+		throttleState.next = at.Add(time.Duration(float64(count) / rate * float64(time.Second)))
+		throttleState.mu.Unlock()
+		time.Sleep(time.Until(at))
+	}
+	return time.Since(start)
+}
+
+// releaseWrite adapts the rate to how long the write call of a batch took
+func releaseWrite(count int, push time.Duration) {
+	if throttle.LatencyThreshold <= 0 || count == 0 || push <= 0 {
+		return
+	}
+
+	throttleState.mu.Lock()
+	defer throttleState.mu.Unlock()
+	observed := float64(count) / push.Seconds()
+	switch {
+	case push > throttle.LatencyThreshold:
+		rate := throttleState.rate
+		if rate == 0 {
+			rate = observed
+			if throttle.EventsPerSecond > 0 {
+				rate = min(rate, throttle.EventsPerSecond)
+			}
+			throttleState.floor = rate * minRateFactor
+		}
+		throttleState.rate = max(rate/2, throttleState.floor)
+		slog.Warn(fmt.Sprintf("Write call took %.2f seconds, over the %.2f second threshold, slowing down to %.1f values per second",
+			push.Seconds(), throttle.LatencyThreshold.Seconds(), throttleState.rate))
+	case push < throttle.LatencyThreshold/2 && throttleState.rate > 0:
+		throttleState.rate *= 1.25
+		// Back at the configured rate, or no longer holding back the server, the adaptive mode lets go
+		if (throttle.EventsPerSecond > 0 && throttleState.rate >= throttle.EventsPerSecond) ||
+			(throttle.EventsPerSecond == 0 && throttleState.rate >= observed*2) {
+			throttleState.rate = 0
+			slog.Info("Write calls are fast again, back to the full write rate")
+		}
+	}
+}
+
+// currentRate is the rate batches are paced at, the adaptive rate while it is slowed down
+func currentRate() float64 {
+	throttleState.mu.Lock()
+	defer throttleState.mu.Unlock()
+	if throttleState.rate > 0 {
+		return throttleState.rate
+	}
+	return throttle.EventsPerSecond
+}
+
+// waitForWindow sleeps until the next write window opens when the current time is outside all of them
+func waitForWindow() {
+	if len(throttle.Windows) == 0 {
+		return
+	}
+	now := time.Now()
+	if inWindow(throttle.Windows, now) {
+		return
+	}
+	next := nextWindow(throttle.Windows, now)
+	slog.Info(fmt.Sprintf("Outside the write windows, pausing until %s", next.Format("2006-01-02 15:04")))
+	time.Sleep(time.Until(next))
+}
+
+// inWindow reports whether t falls in one of the windows. A window that ends at or before its start
+// runs past midnight into the next day.
+func inWindow(windows []WriteWindow, t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	clock := t.Sub(midnight)
+	yesterday := midnight.AddDate(0, 0, -1).Weekday()
+	for _, w := range windows {
+		overnight := w.End <= w.Start
+		if w.onDay(t.Weekday()) && clock >= w.Start && (overnight || clock < w.End) {
+			return true
+		}
+		if overnight && w.onDay(yesterday) && clock < w.End {
+			return true
+		}
+	}
+	return false
+}
+
+// nextWindow finds when the next window opens after t
+func nextWindow(windows []WriteWindow, t time.Time) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for day := 0; day <= 7; day++ {
+		date := midnight.AddDate(0, 0, day)
+		var earliest time.Time
+		for _, w := range windows {
+			start := date.Add(w.Start)
+			if !w.onDay(date.Weekday()) || !start.After(t) {
+				continue
+			}
+			if earliest.IsZero() || start.Before(earliest) {
+				earliest = start
+			}
+		}
+		if !earliest.IsZero() {
+			return earliest
+		}
+	}
+	return t
+}
+
+func (w WriteWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseWriteWindows reads a comma separated list of windows like "22:00-06:00" or "Sat-Sun 00:00-24:00",
+// times are local, days are a single weekday or a range
+func ParseWriteWindows(s string) ([]WriteWindow, error) {
+	var windows []WriteWindow
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var w WriteWindow
+		days, clock, hasDays := strings.Cut(entry, " ")
+		if !hasDays {
+			clock = days
+		} else {
+			var err error
+			if w.Days, err = parseDays(days); err != nil {
+				return nil, fmt.Errorf("invalid write window %q: %w", entry, err)
+			}
+		}
+
+		from, to, ok := strings.Cut(strings.TrimSpace(clock), "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid write window %q, expected HH:MM-HH:MM", entry)
+		}
+		var err error
+		if w.Start, err = parseClock(from); err != nil {
+			return nil, fmt.Errorf("invalid write window %q: %w", entry, err)
+		}
+		if w.End, err = parseClock(to); err != nil {
+			return nil, fmt.Errorf("invalid write window %q: %w", entry, err)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parseDays(s string) ([]time.Weekday, error) {
+	from, to, isRange := strings.Cut(strings.ToLower(s), "-")
+	first, ok := weekdays[from]
+	if !ok {
+		return nil, fmt.Errorf("unknown weekday %q", from)
+	}
+	if !isRange {
+		return []time.Weekday{first}, nil
+	}
+	last, ok := weekdays[to]
+	if !ok {
+		return nil, fmt.Errorf("unknown weekday %q", to)
+	}
+	days := []time.Weekday{first}
+	for d := first; d != last; {
+		d = (d + 1) % 7
+		days = append(days, d)
+	}
+	return days, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	hours, minutes, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid hour in %q", s)
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid minutes in %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
@@ -0,0 +1,111 @@
+package LibFTH
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseWriteWindows(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []WriteWindow
+	}{
+		{"", nil},
+		{"22:00-06:00", []WriteWindow{{Start: 22 * time.Hour, End: 6 * time.Hour}}},
+		{"Sat-Sun 00:00-24:00", []WriteWindow{{Days: []time.Weekday{time.Saturday, time.Sunday}, Start: 0, End: 24 * time.Hour}}},
+		{"fri-mon 20:30-06:15", []WriteWindow{{Days: []time.Weekday{time.Friday, time.Saturday, time.Sunday, time.Monday}, Start: 20*time.Hour + 30*time.Minute, End: 6*time.Hour + 15*time.Minute}}},
+		{"Wed 12:00-13:00, 22:00-23:00", []WriteWindow{
+			{Days: []time.Weekday{time.Wednesday}, Start: 12 * time.Hour, End: 13 * time.Hour},
+			{Start: 22 * time.Hour, End: 23 * time.Hour},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := ParseWriteWindows(tt.spec)
+		if err != nil {
+			t.Errorf("ParseWriteWindows(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseWriteWindows(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{
+		"22:00",
+		"22-06",
+		"25:00-06:00",
+		"22:60-06:00",
+		"24:30-06:00",
+		"-1:00-06:00",
+		"22:00-xx:00",
+		"Someday 22:00-06:00",
+		"Mon-Funday 22:00-06:00",
+		"22:00-06:00,Mon",
+	} {
+		if windows, err := ParseWriteWindows(spec); err == nil {
+			t.Errorf("ParseWriteWindows(%q) = %+v, want an error", spec, windows)
+		}
+	}
+}
+
+// at returns a time in the week of Monday, January 1 2024
+func at(day time.Weekday, hour int, minute int) time.Time {
+	return time.Date(2024, time.January, 1+(int(day)+6)%7, hour, minute, 0, 0, time.UTC)
+}
+
+func TestInWindow(t *testing.T) {
+	weeknights, _ := ParseWriteWindows("Mon-Fri 20:00-06:00")
+	weekend, _ := ParseWriteWindows("Sat-Sun 00:00-24:00")
+	daily, _ := ParseWriteWindows("22:00-02:00")
+	allDay, _ := ParseWriteWindows("00:00-00:00")
+	tests := []struct {
+		name    string
+		windows []WriteWindow
+		t       time.Time
+		want    bool
+	}{
+		{"in the evening part", weeknights, at(time.Monday, 21, 0), true},
+		{"at the start", weeknights, at(time.Monday, 20, 0), true},
+		{"before the start", weeknights, at(time.Monday, 19, 59), false},
+		{"past midnight", weeknights, at(time.Tuesday, 5, 59), true},
+		{"at the end", weeknights, at(time.Tuesday, 6, 0), false},
+		{"past midnight after a day without window", weeknights, at(time.Monday, 5, 0), false},
+		{"past midnight into a day without window", weeknights, at(time.Saturday, 5, 0), true},
+		{"evening of a day without window", weeknights, at(time.Saturday, 21, 0), false},
+		{"whole day", weekend, at(time.Sunday, 23, 59), true},
+		{"day after the whole days", weekend, at(time.Monday, 0, 0), false},
+		{"every day before midnight", daily, at(time.Wednesday, 23, 0), true},
+		{"every day after midnight", daily, at(time.Thursday, 1, 0), true},
+		{"every day outside", daily, at(time.Thursday, 12, 0), false},
+		{"start equals end", allDay, at(time.Thursday, 12, 0), true},
+		{"no windows", nil, at(time.Thursday, 12, 0), false},
+	}
+	for _, tt := range tests {
+		if got := inWindow(tt.windows, tt.t); got != tt.want {
+			t.Errorf("%s: inWindow at %s = %v, want %v", tt.name, tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestNextWindow(t *testing.T) {
+	weeknights, _ := ParseWriteWindows("Mon-Fri 20:00-06:00")
+	both, _ := ParseWriteWindows("Mon-Fri 20:00-06:00,Sat-Sun 00:00-24:00")
+	tests := []struct {
+		name    string
+		windows []WriteWindow
+		t       time.Time
+		want    time.Time
+	}{
+		{"later the same day", weeknights, at(time.Monday, 10, 0), at(time.Monday, 20, 0)},
+		{"over the weekend", weeknights, at(time.Saturday, 10, 0), at(time.Monday, 20, 0).AddDate(0, 0, 7)},
+		{"at a start the next one is taken", weeknights, at(time.Tuesday, 20, 0), at(time.Wednesday, 20, 0)},
+		{"the earliest of several", both, at(time.Friday, 10, 0), at(time.Friday, 20, 0)},
+		{"a whole day window from midnight", both, at(time.Friday, 21, 0), at(time.Saturday, 0, 0)},
+	}
+	for _, tt := range tests {
+		if got := nextWindow(tt.windows, tt.t); !got.Equal(tt.want) {
+			t.Errorf("%s: nextWindow after %s = %s, want %s", tt.name, tt.t.Format("Mon 2 15:04"), got.Format("Mon 2 15:04"), tt.want.Format("Mon 2 15:04"))
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package LibFTH
+
+/*
+#include "pitimestamp.h"
+*/
+import "C"
+import (
+	"unsafe"
+
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// The Go and C timestamps must match to the byte, or this doesn't compile
+var _ [LibPI.PITimestampSize]byte = [unsafe.Sizeof(C.struct_PITIMESTAMP{})]byte{}
+var _ [unsafe.Offsetof(LibPI.PITIMESTAMP{}.Second)]byte = [unsafe.Offsetof(C.struct_PITIMESTAMP{}.second)]byte{}
+var _ [unsafe.Offsetof(LibPI.PITIMESTAMP{}.Tzinfo)]byte = [unsafe.Offsetof(C.struct_PITIMESTAMP{}.tzinfo)]byte{}
+
+// cTimestamps passes a slice of timestamps to the DLL without copying it
+func cTimestamps(ts []LibPI.PITIMESTAMP) *C.struct_PITIMESTAMP {
+	return (*C.struct_PITIMESTAMP)(unsafe.Pointer(&ts[0]))
+}
+
+// cTimestamp passes a single timestamp to the DLL, it may be written to
+func cTimestamp(ts *LibPI.PITIMESTAMP) *C.struct_PITIMESTAMP {
+	return (*C.struct_PITIMESTAMP)(unsafe.Pointer(ts))
+}
@@ -0,0 +1,101 @@
+package LibFTH
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of requests served by the DLL worker, used for the queue metrics
+const (
+	requestLookup = "lookup"
+	requestWrite  = "write"
+	requestAdmin  = "admin"
+)
+
+// dllRequest is a piece of work that calls into piapi
+type dllRequest struct {
+	kind    string
+	fn      func()
+	queued  time.Time
+	started time.Time
+	done    chan struct{}
+}
+
+// requests is served by a single goroutine locked to its OS thread, since piapi connections
+// belong to the thread that made them. Requests are served in the order they were queued,
+// so the batches of a point are written in the order they were sent.
+var requests = make(chan *dllRequest, 256)
+var startWorker sync.Once
+
+// queueDepth counts the requests waiting for the worker
+var queueDepth atomic.Int64
+
+// WorkerStats describes the requests served by the DLL worker
+type WorkerStats struct {
+	Requests      map[string]int
+	MaxQueueDepth int64
+	Wait          time.Duration
+	Busy          time.Duration
+}
+
+var statsMu sync.Mutex
+var stats = WorkerStats{Requests: make(map[string]int)}
+
+func serve() {
+	runtime.LockOSThread()
+	for req := range requests {
+		queueDepth.Add(-1)
+		req.started = time.Now()
+		req.fn()
+
+		statsMu.Lock()
+		stats.Requests[req.kind]++
+		stats.Wait += req.started.Sub(req.queued)
+		stats.Busy += time.Since(req.started)
+		statsMu.Unlock()
+		close(req.done)
+	}
+}
+
+// do runs fn on the DLL worker and returns how long the request waited in the queue.
+// fn must not call do itself, functions named ...OnWorker are meant to be called from it.
+func do(kind string, fn func()) time.Duration {
+	startWorker.Do(func() { go serve() })
+
+	req := &dllRequest{kind: kind, fn: fn, queued: time.Now(), done: make(chan struct{})}
+	depth := queueDepth.Add(1)
+	statsMu.Lock()
+	stats.MaxQueueDepth = max(stats.MaxQueueDepth, depth)
+	statsMu.Unlock()
+
+	requests <- req
+	<-req.done
+	return req.started.Sub(req.queued)
+}
+
+// GetWorkerStats returns the request counts and timings of the DLL worker so far
+func GetWorkerStats() WorkerStats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	copied := stats
+	copied.Requests = make(map[string]int, len(stats.Requests))
+	for kind, n := range stats.Requests {
+		copied.Requests[kind] = n
+	}
+	return copied
+}
+
+// QueueDepth returns how many requests are waiting for the DLL worker right now
+func QueueDepth() int64 {
+	return queueDepth.Load()
+}
+
+// Log prints the request counts and queue metrics of the DLL worker
+func (s WorkerStats) Log() {
+	slog.Info(fmt.Sprintf("DLL worker: %d lookups, %d writes, %d other requests, max queue depth %d, queued %.2f seconds, busy %.2f seconds",
+		s.Requests[requestLookup], s.Requests[requestWrite], s.Requests[requestAdmin], s.MaxQueueDepth, s.Wait.Seconds(), s.Busy.Seconds()))
+}
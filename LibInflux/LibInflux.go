@@ -0,0 +1,324 @@
+package LibInflux
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// Series describes how a datalog tag is written as an InfluxDB point
+type Series struct {
+	Measurement string
+	Field       string
+	Tags        map[string]string
+}
+
+// Encoder turns datalog records into InfluxDB line protocol
+type Encoder struct {
+	Defaults Series
+	TagKey   string
+	// Location is the zone of the datalog wall-clock times, the machine's own zone by default like piapi
+	Location *time.Location
+	series   map[string]Series
+}
+
+func NewEncoder(measurement string, field string, tagKey string, series map[string]Series) *Encoder {
+	if series == nil {
+		series = make(map[string]Series)
+	}
+	return &Encoder{
+		Defaults: Series{Measurement: measurement, Field: field},
+		TagKey:   tagKey,
+		Location: time.Local,
+		series:   series,
+	}
+}
+
+// LoadSeriesMapCSV reads the optional influx columns of the tag map CSV.
+// Rows are: datalog tag, historian tag, measurement, field, tags (key=value;key=value).
+// Empty or missing columns fall back to the encoder defaults.
+func LoadSeriesMapCSV(filePath string, series map[string]Series) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	for i := 0; ; i++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading CSV file at line %d: %w", i+1, err)
+		}
+		if len(record) < 3 {
+			continue
+		}
+
+		s := Series{Measurement: strings.TrimSpace(record[2])}
+		if len(record) > 3 {
+			s.Field = strings.TrimSpace(record[3])
+		}
+		if len(record) > 4 && strings.TrimSpace(record[4]) != "" {
+			s.Tags = make(map[string]string)
+			for _, pair := range strings.Split(record[4], ";") {
+				k, v, ok := strings.Cut(pair, "=")
+				if !ok || strings.TrimSpace(k) == "" {
+					slog.Error(fmt.Sprintf("Invalid influx tag %q on row %d", pair, i+1))
+					continue
+				}
+				s.Tags[strings.TrimSpace(k)] = strings.TrimSpace(v)
+			}
+		}
+		series[strings.ToUpper(record[0])] = s
+	}
+
+	return nil
+}
+
+func (e *Encoder) seriesFor(datalogName string) Series {
+	s := e.Defaults
+	if override, ok := e.series[strings.ToUpper(datalogName)]; ok {
+		if override.Measurement != "" {
+			s.Measurement = override.Measurement
+		}
+		if override.Field != "" {
+			s.Field = override.Field
+		}
+		s.Tags = override.Tags
+	}
+	return s
+}
+
+// AppendRecord appends one line of line protocol for record to buf
+func (e *Encoder) AppendRecord(buf []byte, record *LibDAT.DatFloatRecord, point *LibPI.PointCache) []byte {
+	s := e.seriesFor(point.DatalogName)
+
+	buf = appendEscaped(buf, s.Measurement, ", ")
+	if e.TagKey != "" {
+		buf = append(buf, ',')
+		buf = appendEscaped(buf, e.TagKey, ",= ")
+		buf = append(buf, '=')
+		buf = appendEscaped(buf, point.PIName, ",= ")
+	}
+
+	keys := make([]string, 0, len(s.Tags))
+	for k := range s.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if s.Tags[k] == "" {
+			continue
+		}
+		buf = append(buf, ',')
+		buf = appendEscaped(buf, k, ",= ")
+		buf = append(buf, '=')
+		buf = appendEscaped(buf, s.Tags[k], ",= ")
+	}
+
+	buf = append(buf, ' ')
+	buf = appendEscaped(buf, s.Field, ",= ")
+	buf = append(buf, '=')
+	buf = strconv.AppendFloat(buf, record.Val, 'g', -1, 64)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, e.timestamp(record.TimeStamp), 10)
+	return append(buf, '\n')
+}
+
+// timestamp converts a datalog time to Unix nanoseconds. Datalog times are wall-clock times read as UTC,
+// so they are placed in Location first.
+func (e *Encoder) timestamp(t time.Time) int64 {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), e.Location)
+	return wall.UnixNano()
+}
+
+func appendEscaped(buf []byte, s string, special string) []byte {
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(special, s[i]) >= 0 || s[i] == '\\' {
+			buf = append(buf, '\\')
+		}
+		buf = append(buf, s[i])
+	}
+	return buf
+}
+
+// encode converts the processable records of a file into line protocol lines. Line protocol has no
+// NaN or infinite floats and InfluxDB rejects the whole batch for one, so those values are left out.
+func (e *Encoder) encode(records []*LibDAT.DatFloatRecord, pointLookup *LibPI.PointLookup) [][]byte {
+	lines := make([][]byte, 0, len(records))
+	notFinite := 0
+	for _, record := range records {
+		if record == nil || !record.IsValid {
+			continue
+		}
+		point, exists := pointLookup.GetPointByDataLogID(record.TagID)
+		if !exists || !point.Process {
+			continue
+		}
+		if math.IsNaN(record.Val) || math.IsInf(record.Val, 0) {
+			notFinite++
+			continue
+		}
+		lines = append(lines, e.AppendRecord(nil, record, point))
+	}
+	if notFinite > 0 {
+		slog.Warn(fmt.Sprintf("Skipped %d NaN or infinite values, line protocol can't hold them", notFinite))
+	}
+	return lines
+}
+
+// FileWriter writes line protocol to a local file
+type FileWriter struct {
+	mu      sync.Mutex
+	encoder *Encoder
+	file    *os.File
+}
+
+func NewFileWriter(path string, encoder *Encoder) (*FileWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create line protocol file: %w", err)
+	}
+	return &FileWriter{encoder: encoder, file: file}, nil
+}
+
+func (fw *FileWriter) WriteRecords(records []*LibDAT.DatFloatRecord, pointLookup *LibPI.PointLookup) error {
+	lines := fw.encoder.encode(records, pointLookup)
+	if len(lines) < 1 {
+		return fmt.Errorf("no valid entries to write to line protocol file")
+	}
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	for _, line := range lines {
+		if _, err := fw.file.Write(line); err != nil {
+			return fmt.Errorf("failed to write line protocol: %w", err)
+		}
+	}
+	slog.Info(fmt.Sprintf("Wrote %d records to %s", len(lines), fw.file.Name()))
+	return nil
+}
+
+func (fw *FileWriter) Close() error {
+	return fw.file.Close()
+}
+
+// HTTPWriter posts batches of line protocol to an InfluxDB /api/v2/write endpoint
+type HTTPWriter struct {
+	encoder    *Encoder
+	client     *http.Client
+	writeURL   string
+	token      string
+	BatchSize  int
+	MaxRetries int
+	RetryDelay time.Duration
+}
+
+func NewHTTPWriter(serverURL string, org string, bucket string, token string, encoder *Encoder) (*HTTPWriter, error) {
+	u, err := url.Parse(strings.TrimSuffix(serverURL, "/") + "/api/v2/write")
+	if err != nil {
+		return nil, fmt.Errorf("invalid influx url %s: %w", serverURL, err)
+	}
+	q := u.Query()
+	q.Set("org", org)
+	q.Set("bucket", bucket)
+	q.Set("precision", "ns")
+	u.RawQuery = q.Encode()
+
+	return &HTTPWriter{
+		encoder:    encoder,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		writeURL:   u.String(),
+		token:      token,
+		BatchSize:  5000,
+		MaxRetries: 5,
+		RetryDelay: time.Second,
+	}, nil
+}
+
+func (hw *HTTPWriter) WriteRecords(records []*LibDAT.DatFloatRecord, pointLookup *LibPI.PointLookup) error {
+	lines := hw.encoder.encode(records, pointLookup)
+	if len(lines) < 1 {
+		return fmt.Errorf("no valid entries to write to influx")
+	}
+
+	start := time.Now()
+	batchSize := hw.BatchSize
+	if batchSize < 1 {
+		batchSize = len(lines)
+	}
+	for i := 0; i < len(lines); i += batchSize {
+		end := min(i+batchSize, len(lines))
+		if err := hw.post(bytes.Join(lines[i:end], nil)); err != nil {
+			return fmt.Errorf("failed writing records %d-%d to influx: %w", i, end-1, err)
+		}
+	}
+	slog.Info(fmt.Sprintf("Wrote %d records to influx in %.2f seconds", len(lines), time.Since(start).Seconds()))
+	return nil
+}
+
+func (hw *HTTPWriter) post(body []byte) error {
+	delay := hw.RetryDelay
+	var lastErr error
+	for attempt := 0; attempt <= hw.MaxRetries; attempt++ {
+		if attempt > 0 {
+			slog.Debug(fmt.Sprintf("Retrying influx write in %s (attempt %d): %v", delay, attempt, lastErr))
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		req, err := http.NewRequest(http.MethodPost, hw.writeURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		if hw.token != "" {
+			req.Header.Set("Authorization", "Token "+hw.token)
+		}
+
+		resp, err := hw.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			lastErr = fmt.Errorf("influx returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+			if after, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && after > 0 {
+				delay = time.Duration(after) * time.Second
+			}
+		default:
+			return fmt.Errorf("influx returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		}
+	}
+	return fmt.Errorf("giving up after %d retries: %w", hw.MaxRetries, lastErr)
+}
+
+func (hw *HTTPWriter) Close() error {
+	hw.client.CloseIdleConnections()
+	return nil
+}
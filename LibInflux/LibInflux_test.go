@@ -0,0 +1,196 @@
+package LibInflux
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+var testTime = time.Date(2024, time.January, 2, 10, 0, 0, 250*int(time.Millisecond), time.UTC)
+
+// testFile builds the records and point lookup of one tag with the given values, a second apart
+func testFile(name string, values ...float64) ([]*LibDAT.DatFloatRecord, *LibPI.PointLookup) {
+	lookup := LibPI.NewPointLookup()
+	lookup.AddPoint(&LibPI.PointCache{DatalogName: name, DataLogID: 1, Process: true, PIName: name})
+	records := make([]*LibDAT.DatFloatRecord, len(values))
+	for i, v := range values {
+		records[i] = &LibDAT.DatFloatRecord{TimeStamp: testTime.Add(time.Duration(i) * time.Second), TagID: 1, Val: v, IsValid: true}
+	}
+	return records, lookup
+}
+
+func TestAppendRecordEscaping(t *testing.T) {
+	series := map[string]Series{
+		`ZONE\1`: {Measurement: "oven temps,line", Field: "deg C", Tags: map[string]string{"zone": "a=1", "area": "oven hall", "empty": ""}},
+	}
+	e := NewEncoder("datalog", "value", "tag", series)
+	e.Location = time.UTC
+	point := &LibPI.PointCache{DatalogName: `zone\1`, PIName: `zone 1,temp=x\y`}
+	record := &LibDAT.DatFloatRecord{TimeStamp: testTime, Val: 21.5}
+
+	got := string(e.AppendRecord(nil, record, point))
+	want := `oven\ temps\,line,tag=zone\ 1\,temp\=x\\y,area=oven\ hall,zone=a\=1 deg\ C=21.5 1704189600250000000` + "\n"
+	if got != want {
+		t.Fatalf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestTimestampZone(t *testing.T) {
+	e := NewEncoder("datalog", "value", "tag", nil)
+	e.Location = time.FixedZone("UTC+2", 2*60*60)
+	if got, want := e.timestamp(testTime), testTime.Add(-2*time.Hour).UnixNano(); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	// The offset follows daylight saving time on the date of the value
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	e.Location = berlin
+	summer := time.Date(2024, time.July, 1, 12, 0, 0, 0, time.UTC)
+	if got, want := e.timestamp(summer), summer.Add(-2*time.Hour).UnixNano(); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	winter := time.Date(2024, time.December, 1, 12, 0, 0, 0, time.UTC)
+	if got, want := e.timestamp(winter), winter.Add(-time.Hour).UnixNano(); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestEncodeSkipsNotFinite(t *testing.T) {
+	records, lookup := testFile("A", 1, math.NaN(), math.Inf(1), math.Inf(-1), 2)
+	lines := NewEncoder("datalog", "value", "tag", nil).encode(records, lookup)
+	if len(lines) != 2 {
+		t.Fatalf("encoded %d lines, want 2: %q", len(lines), lines)
+	}
+	for _, line := range lines {
+		if strings.Contains(string(line), "NaN") || strings.Contains(string(line), "Inf") {
+			t.Errorf("line %q holds a value line protocol can't", line)
+		}
+	}
+}
+
+// testServer records the write requests it gets and answers them with the given handler
+type testServer struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func newTestServer(t *testing.T, answer func(n int, w http.ResponseWriter)) (*testServer, *httptest.Server) {
+	ts := &testServer{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ts.mu.Lock()
+		ts.requests = append(ts.requests, r)
+		ts.bodies = append(ts.bodies, string(body))
+		n := len(ts.requests)
+		ts.mu.Unlock()
+		answer(n, w)
+	}))
+	t.Cleanup(server.Close)
+	return ts, server
+}
+
+func TestHTTPBatching(t *testing.T) {
+	ts, server := newTestServer(t, func(int, http.ResponseWriter) {})
+	hw, err := NewHTTPWriter(server.URL+"/", "plant", "history", "secret", NewEncoder("datalog", "value", "tag", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hw.BatchSize = 2
+
+	records, lookup := testFile("A", 1, 2, 3, 4, 5)
+	if err := hw.WriteRecords(records, lookup); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ts.requests) != 3 {
+		t.Fatalf("got %d requests, want 3", len(ts.requests))
+	}
+	for i, want := range []int{2, 2, 1} {
+		r := ts.requests[i]
+		if r.URL.Path != "/api/v2/write" {
+			t.Errorf("request %d went to %s", i, r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("org") != "plant" || q.Get("bucket") != "history" || q.Get("precision") != "ns" {
+			t.Errorf("request %d has query %s", i, r.URL.RawQuery)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Token secret" {
+			t.Errorf("request %d has Authorization %q", i, auth)
+		}
+		if lines := strings.Count(ts.bodies[i], "\n"); lines != want {
+			t.Errorf("request %d has %d lines, want %d", i, lines, want)
+		}
+	}
+}
+
+func TestHTTPRetryAfter(t *testing.T) {
+	ts, server := newTestServer(t, func(n int, w http.ResponseWriter) {
+		if n == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+	hw, _ := NewHTTPWriter(server.URL, "plant", "history", "", NewEncoder("datalog", "value", "tag", nil))
+	hw.RetryDelay = time.Millisecond
+
+	records, lookup := testFile("A", 1)
+	start := time.Now()
+	if err := hw.WriteRecords(records, lookup); err != nil {
+		t.Fatal(err)
+	}
+	if len(ts.requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(ts.requests))
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("retried after %s, Retry-After asked for 1s", waited)
+	}
+	if ts.bodies[0] != ts.bodies[1] {
+		t.Errorf("retry sent %q, first request %q", ts.bodies[1], ts.bodies[0])
+	}
+}
+
+func TestHTTPRetries(t *testing.T) {
+	ts, server := newTestServer(t, func(n int, w http.ResponseWriter) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	hw, _ := NewHTTPWriter(server.URL, "plant", "history", "", NewEncoder("datalog", "value", "tag", nil))
+	hw.RetryDelay = time.Millisecond
+	hw.MaxRetries = 2
+
+	records, lookup := testFile("A", 1)
+	if err := hw.WriteRecords(records, lookup); err == nil {
+		t.Fatal("write succeeded against a server that is down")
+	}
+	if len(ts.requests) != 3 {
+		t.Errorf("got %d requests, want the first and 2 retries", len(ts.requests))
+	}
+}
+
+func TestHTTPBadRequestNotRetried(t *testing.T) {
+	ts, server := newTestServer(t, func(n int, w http.ResponseWriter) {
+		http.Error(w, "unable to parse", http.StatusBadRequest)
+	})
+	hw, _ := NewHTTPWriter(server.URL, "plant", "history", "", NewEncoder("datalog", "value", "tag", nil))
+	hw.RetryDelay = time.Millisecond
+
+	records, lookup := testFile("A", 1)
+	err := hw.WriteRecords(records, lookup)
+	if err == nil || !strings.Contains(err.Error(), "unable to parse") {
+		t.Fatalf("got error %v, want the message of the server", err)
+	}
+	if len(ts.requests) != 1 {
+		t.Errorf("got %d requests, a bad request must not be retried", len(ts.requests))
+	}
+}
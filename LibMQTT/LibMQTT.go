@@ -0,0 +1,311 @@
+package LibMQTT
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// Format selects the payload published for each record
+type Format int
+
+const (
+	FormatJSON Format = iota
+	FormatSparkplugB
+)
+
+// String provides a string representation of the Format
+func (f Format) String() string {
+	switch f {
+	case FormatJSON:
+		return "json"
+	case FormatSparkplugB:
+		return "spb"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseFormat converts a command line value into a Format
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "json":
+		return FormatJSON, nil
+	case "spb", "sparkplug", "sparkplugb":
+		return FormatSparkplugB, nil
+	default:
+		return FormatJSON, fmt.Errorf("unknown mqtt format %q, expected json or spb", s)
+	}
+}
+
+type Options struct {
+	Broker   string
+	ClientID string
+	User     string
+	Password string
+	QoS      byte
+	Format   Format
+
+	// TopicPrefix is prepended to the mapped tag name for JSON payloads
+	TopicPrefix string
+
+	// Sparkplug B topic parts: spBv1.0/<GroupID>/<message type>/<EdgeNode>/<Device>
+	GroupID  string
+	EdgeNode string
+	Device   string
+
+	// Speed replays records with their original spacing divided by Speed. Zero publishes as fast as possible.
+	Speed float64
+
+	// Location is the zone of the datalog wall-clock times, the machine's own zone when nil
+	Location *time.Location
+}
+
+// Publisher replays datalog records to an MQTT broker
+type Publisher struct {
+	mu     sync.Mutex
+	client mqtt.Client
+	opts   Options
+	seq    uint64
+	bdSeq  uint64
+
+	// aliases numbers the metrics declared in the DBIRTH of the device, from 1
+	aliases map[string]uint64
+	born    bool
+}
+
+func NewPublisher(opts Options) (*Publisher, error) {
+	if opts.QoS > 2 {
+		return nil, fmt.Errorf("invalid mqtt qos %d", opts.QoS)
+	}
+
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	p := &Publisher{opts: opts, aliases: make(map[string]uint64)}
+	co := mqtt.NewClientOptions().
+		AddBroker(opts.Broker).
+		SetClientID(opts.ClientID).
+		SetUsername(opts.User).
+		SetPassword(opts.Password).
+		SetAutoReconnect(true).
+		SetOrderMatters(true)
+
+	if opts.Format == FormatSparkplugB {
+		p.bdSeq = uint64(time.Now().Unix() % 256)
+		co.SetBinaryWill(p.nodeTopic("NDEATH"), p.encodeNodeState(), 1, false)
+	}
+
+	p.client = mqtt.NewClient(co)
+	token := p.client.Connect()
+	if !token.WaitTimeout(30*time.Second) || token.Error() != nil {
+		return nil, fmt.Errorf("failed to connect to mqtt broker %s: %v", opts.Broker, token.Error())
+	}
+
+	if opts.Format == FormatSparkplugB {
+		// NBIRTH always carries seq 0, the device messages continue from 1
+		birth := appendVarintField(p.encodeNodeState(), 3, p.nextSeq())
+		if err := p.publish(p.nodeTopic("NBIRTH"), birth); err != nil {
+			p.client.Disconnect(250)
+			return nil, fmt.Errorf("failed to publish NBIRTH: %w", err)
+		}
+	}
+	return p, nil
+}
+
+func (p *Publisher) nodeTopic(messageType string) string {
+	return fmt.Sprintf("spBv1.0/%s/%s/%s", p.opts.GroupID, messageType, p.opts.EdgeNode)
+}
+
+func (p *Publisher) deviceTopic(messageType string) string {
+	return fmt.Sprintf("spBv1.0/%s/%s/%s/%s", p.opts.GroupID, messageType, p.opts.EdgeNode, p.opts.Device)
+}
+
+// birthDevice publishes a DBIRTH declaring the name, datatype and alias of every metric before
+// they are sent in DDATA. When a file brings new metrics the device dies and is born again with
+// the full set, host applications drop DDATA of metrics they haven't seen born.
+func (p *Publisher) birthDevice(records []*LibDAT.DatFloatRecord, pointLookup *LibPI.PointLookup) error {
+	added := false
+	for _, record := range records {
+		if record == nil || !record.IsValid {
+			continue
+		}
+		point, exists := pointLookup.GetPointByDataLogID(record.TagID)
+		if !exists || !point.Process {
+			continue
+		}
+		if _, ok := p.aliases[point.PIName]; !ok {
+			p.aliases[point.PIName] = uint64(len(p.aliases) + 1)
+			added = true
+		}
+	}
+	if !added {
+		return nil
+	}
+
+	now := uint64(time.Now().UnixMilli())
+	if p.born {
+		if err := p.publish(p.deviceTopic("DDEATH"), encodePayload(now, p.nextSeq(), nil)); err != nil {
+			return fmt.Errorf("failed to publish DDEATH: %w", err)
+		}
+	}
+	metrics := make([]sparkplugMetric, 0, len(p.aliases))
+	for name, alias := range p.aliases {
+		metrics = append(metrics, sparkplugMetric{name: name, alias: alias, timestamp: now, null: true})
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].alias < metrics[j].alias })
+	if err := p.publish(p.deviceTopic("DBIRTH"), encodePayload(now, p.nextSeq(), metrics)); err != nil {
+		return fmt.Errorf("failed to publish DBIRTH: %w", err)
+	}
+	p.born = true
+	return nil
+}
+
+func (p *Publisher) publish(topic string, payload []byte) error {
+	token := p.client.Publish(topic, p.opts.QoS, false, payload)
+	token.Wait()
+	return token.Error()
+}
+
+type jsonRecord struct {
+	Tag       string  `json:"tag"`
+	Timestamp string  `json:"timestamp"`
+	Value     float64 `json:"value"`
+	Status    string  `json:"status"`
+	Marker    string  `json:"marker"`
+}
+
+// WriteRecords publishes a file's records. Files are replayed one at a time so the pacing of
+// each file is preserved.
+func (p *Publisher) WriteRecords(records []*LibDAT.DatFloatRecord, pointLookup *LibPI.PointLookup) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.opts.Format == FormatSparkplugB {
+		if err := p.birthDevice(records, pointLookup); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	count := 0
+	notFinite := 0
+	var previous time.Time
+	var metrics []sparkplugMetric
+	var metricsTime time.Time
+
+	flushMetrics := func() error {
+		if len(metrics) == 0 {
+			return nil
+		}
+		payload := encodePayload(uint64(p.instant(metricsTime).UnixMilli()), p.nextSeq(), metrics)
+		metrics = metrics[:0]
+		return p.publish(p.deviceTopic("DDATA"), payload)
+	}
+
+	for _, record := range records {
+		if record == nil || !record.IsValid {
+			continue
+		}
+		point, exists := pointLookup.GetPointByDataLogID(record.TagID)
+		if !exists || !point.Process {
+			continue
+		}
+
+		if !record.TimeStamp.Equal(previous) {
+			if err := flushMetrics(); err != nil {
+				return fmt.Errorf("failed to publish DDATA: %w", err)
+			}
+			if p.opts.Speed > 0 && !previous.IsZero() && record.TimeStamp.After(previous) {
+				time.Sleep(time.Duration(float64(record.TimeStamp.Sub(previous)) / p.opts.Speed))
+			}
+			previous = record.TimeStamp
+		}
+
+		switch p.opts.Format {
+		case FormatSparkplugB:
+			metricsTime = record.TimeStamp
+			metrics = append(metrics, sparkplugMetric{
+				alias:     p.aliases[point.PIName],
+				timestamp: uint64(p.instant(record.TimeStamp).UnixMilli()),
+				value:     record.Val,
+			})
+		default:
+			// JSON has no NaN or infinity, Sparkplug B carries them as doubles
+			if math.IsNaN(record.Val) || math.IsInf(record.Val, 0) {
+				notFinite++
+				continue
+			}
+			payload, err := json.Marshal(jsonRecord{
+				Tag:       point.PIName,
+				Timestamp: p.instant(record.TimeStamp).UTC().Format(time.RFC3339Nano),
+				Value:     record.Val,
+				Status:    strings.Trim(string(record.Status), " \x00"),
+				Marker:    strings.Trim(string(record.Marker), " \x00"),
+			})
+			if err != nil {
+				return err
+			}
+			if err := p.publish(p.jsonTopic(point.PIName), payload); err != nil {
+				return fmt.Errorf("failed to publish %s: %w", point.PIName, err)
+			}
+		}
+		count++
+	}
+	if err := flushMetrics(); err != nil {
+		return fmt.Errorf("failed to publish DDATA: %w", err)
+	}
+
+	if notFinite > 0 {
+		slog.Warn(fmt.Sprintf("Skipped %d NaN or infinite values, JSON can't hold them", notFinite))
+	}
+	if count < 1 {
+		return fmt.Errorf("no valid entries to publish")
+	}
+	slog.Info(fmt.Sprintf("Published %d records to %s in %.2f seconds", count, p.opts.Broker, time.Since(start).Seconds()))
+	return nil
+}
+
+// instant places a datalog time in Location. Datalog times are wall-clock times read as UTC.
+func (p *Publisher) instant(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), p.opts.Location)
+}
+
+// jsonTopic builds the topic of a tag. Datalog path separators become topic levels and MQTT wildcards are replaced.
+func (p *Publisher) jsonTopic(name string) string {
+	name = strings.NewReplacer(`\`, "/", "+", "_", "#", "_").Replace(name)
+	if p.opts.TopicPrefix == "" {
+		return name
+	}
+	return strings.TrimSuffix(p.opts.TopicPrefix, "/") + "/" + name
+}
+
+func (p *Publisher) nextSeq() uint64 {
+	seq := p.seq
+	p.seq = (p.seq + 1) % 256
+	return seq
+}
+
+func (p *Publisher) encodeNodeState() []byte {
+	return encodeNodeState(uint64(time.Now().UnixMilli()), p.bdSeq)
+}
+
+func (p *Publisher) Close() error {
+	if p.opts.Format == FormatSparkplugB {
+		if err := p.publish(p.nodeTopic("NDEATH"), p.encodeNodeState()); err != nil {
+			slog.Error(fmt.Sprintf("Failed to publish NDEATH: %v", err))
+		}
+	}
+	p.client.Disconnect(1000)
+	return nil
+}
@@ -0,0 +1,407 @@
+package LibMQTT
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// message is a PUBLISH received by the test broker
+type message struct {
+	topic   string
+	payload []byte
+}
+
+// testBroker is an in-process MQTT broker that accepts every client and records what is published
+type testBroker struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	messages []message
+	will     message
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBroker{listener: listener}
+	go b.serve()
+	t.Cleanup(func() { listener.Close() })
+	return b
+}
+
+func (b *testBroker) url() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *testBroker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *testBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			b.mu.Lock()
+			b.will = message{topic: p.WillTopic, payload: p.WillMessage}
+			b.mu.Unlock()
+			packets.NewControlPacket(packets.Connack).Write(conn)
+		case *packets.PublishPacket:
+			b.mu.Lock()
+			b.messages = append(b.messages, message{topic: p.TopicName, payload: p.Payload})
+			b.mu.Unlock()
+			if p.Qos == 1 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				ack.Write(conn)
+			}
+		case *packets.PingreqPacket:
+			packets.NewControlPacket(packets.Pingresp).Write(conn)
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+// wait returns the first n messages, failing when they don't arrive in time
+func (b *testBroker) wait(t *testing.T, n int) []message {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		if len(b.messages) >= n {
+			messages := append([]message(nil), b.messages[:n]...)
+			b.mu.Unlock()
+			return messages
+		}
+		got := len(b.messages)
+		b.mu.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d messages, want %d", got, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// field is a decoded protobuf field
+type field struct {
+	num    int
+	varint uint64
+	bytes  []byte
+}
+
+// decode splits a protobuf message into its fields, fixed64 values are returned as varint
+func decode(t *testing.T, b []byte) []field {
+	t.Helper()
+	var fields []field
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("bad tag in % x", b)
+		}
+		b = b[n:]
+		f := field{num: int(tag >> 3)}
+		switch tag & 7 {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(b)
+			b = b[n:]
+		case wireFixed64:
+			f.varint = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			f.bytes = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// byNumber indexes fields by number, repeated fields keep every occurrence
+func byNumber(fields []field) map[int][]field {
+	m := make(map[int][]field)
+	for _, f := range fields {
+		m[f.num] = append(m[f.num], f)
+	}
+	return m
+}
+
+func TestEncodeDataMetric(t *testing.T) {
+	fields := byNumber(decode(t, encodeMetric(sparkplugMetric{alias: 3, timestamp: 1704189600250, value: 21.5})))
+
+	if _, ok := fields[1]; ok {
+		t.Error("DDATA metric carries a name, it should only carry the alias")
+	}
+	checks := []struct {
+		name string
+		num  int
+		want uint64
+	}{
+		{"alias", 2, 3},
+		{"timestamp", 3, 1704189600250},
+		{"datatype", 4, sparkplugDouble},
+		{"is_historical", 5, 1},
+		{"double_value", 13, math.Float64bits(21.5)},
+	}
+	for _, c := range checks {
+		if got := fields[c.num]; len(got) != 1 || got[0].varint != c.want {
+			t.Errorf("%s (field %d) is %v, want %d", c.name, c.num, got, c.want)
+		}
+	}
+}
+
+func TestEncodeBirthMetric(t *testing.T) {
+	fields := byNumber(decode(t, encodeMetric(sparkplugMetric{name: "zone1_temp", alias: 1, timestamp: 1, null: true})))
+
+	if got := fields[1]; len(got) != 1 || string(got[0].bytes) != "zone1_temp" {
+		t.Errorf("name is %v, want zone1_temp", got)
+	}
+	if got := fields[2]; len(got) != 1 || got[0].varint != 1 {
+		t.Errorf("alias is %v, want 1", got)
+	}
+	if got := fields[4]; len(got) != 1 || got[0].varint != sparkplugDouble {
+		t.Errorf("datatype is %v, want Double", got)
+	}
+	if got := fields[7]; len(got) != 1 || got[0].varint != 1 {
+		t.Errorf("is_null is %v, want 1", got)
+	}
+	if _, ok := fields[13]; ok {
+		t.Error("DBIRTH metric declared without a value carries one")
+	}
+}
+
+func TestEncodeNodeState(t *testing.T) {
+	payload := byNumber(decode(t, encodeNodeState(1704189600000, 42)))
+	if got := payload[1]; len(got) != 1 || got[0].varint != 1704189600000 {
+		t.Errorf("payload timestamp is %v", got)
+	}
+	if _, ok := payload[3]; ok {
+		t.Error("node state payload carries a seq")
+	}
+	if len(payload[2]) != 1 {
+		t.Fatalf("node state has %d metrics, want 1", len(payload[2]))
+	}
+	metric := byNumber(decode(t, payload[2][0].bytes))
+	if got := metric[1]; len(got) != 1 || string(got[0].bytes) != "bdSeq" {
+		t.Errorf("metric name is %v, want bdSeq", got)
+	}
+	if got := metric[4]; len(got) != 1 || got[0].varint != sparkplugUInt64 {
+		t.Errorf("bdSeq datatype is %v, want UInt64", got)
+	}
+	if got := metric[11]; len(got) != 1 || got[0].varint != 42 {
+		t.Errorf("bdSeq long_value is %v, want 42", got)
+	}
+}
+
+func TestSeqWraps(t *testing.T) {
+	p := &Publisher{seq: 254}
+	for _, want := range []uint64{254, 255, 0, 1} {
+		if got := p.nextSeq(); got != want {
+			t.Fatalf("seq is %d, want %d", got, want)
+		}
+	}
+}
+
+// testFile builds the records and point lookup of a file with one value per tag at each timestamp
+func testFile(tags []string, times []time.Time) ([]*LibDAT.DatFloatRecord, *LibPI.PointLookup) {
+	lookup := LibPI.NewPointLookup()
+	for id, tag := range tags {
+		lookup.AddPoint(&LibPI.PointCache{DatalogName: tag, DataLogID: id, Process: true, PIName: tag})
+	}
+	var records []*LibDAT.DatFloatRecord
+	for i, ts := range times {
+		for id := range tags {
+			records = append(records, &LibDAT.DatFloatRecord{TimeStamp: ts, TagID: id, Val: float64(10*i + id), Status: ' ', Marker: ' ', IsValid: true})
+		}
+	}
+	return records, lookup
+}
+
+// sparkplugMessage is a decoded Sparkplug B payload
+type sparkplugMessage struct {
+	topic   string
+	seq     []field
+	metrics []map[int][]field
+}
+
+func decodeSparkplug(t *testing.T, m message) sparkplugMessage {
+	t.Helper()
+	payload := byNumber(decode(t, m.payload))
+	decoded := sparkplugMessage{topic: m.topic, seq: payload[3]}
+	for _, f := range payload[2] {
+		decoded.metrics = append(decoded.metrics, byNumber(decode(t, f.bytes)))
+	}
+	return decoded
+}
+
+func TestSparkplugPublish(t *testing.T) {
+	broker := newTestBroker(t)
+	p, err := NewPublisher(Options{Broker: broker.url(), ClientID: "test", Format: FormatSparkplugB, GroupID: "g", EdgeNode: "n", Device: "d", Location: time.UTC})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t0 := time.Date(2024, time.January, 2, 10, 0, 0, 0, time.UTC)
+	records, lookup := testFile([]string{"A", "B"}, []time.Time{t0, t0.Add(time.Second)})
+	if err := p.WriteRecords(records, lookup); err != nil {
+		t.Fatal(err)
+	}
+	// The second file brings a new tag, so the device is born again with all three
+	records, lookup = testFile([]string{"A", "B", "C"}, []time.Time{t0.Add(2 * time.Second)})
+	if err := p.WriteRecords(records, lookup); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+
+	want := []struct {
+		topic   string
+		seq     int // -1 for no seq
+		metrics int
+	}{
+		{"spBv1.0/g/NBIRTH/n", 0, 1},
+		{"spBv1.0/g/DBIRTH/n/d", 1, 2},
+		{"spBv1.0/g/DDATA/n/d", 2, 2},
+		{"spBv1.0/g/DDATA/n/d", 3, 2},
+		{"spBv1.0/g/DDEATH/n/d", 4, 0},
+		{"spBv1.0/g/DBIRTH/n/d", 5, 3},
+		{"spBv1.0/g/DDATA/n/d", 6, 3},
+		{"spBv1.0/g/NDEATH/n", -1, 1},
+	}
+	messages := broker.wait(t, len(want))
+	decoded := make([]sparkplugMessage, len(messages))
+	for i, m := range messages {
+		decoded[i] = decodeSparkplug(t, m)
+		w := want[i]
+		if m.topic != w.topic {
+			t.Fatalf("message %d went to %s, want %s", i, m.topic, w.topic)
+		}
+		if w.seq < 0 {
+			if len(decoded[i].seq) != 0 {
+				t.Errorf("message %d carries a seq", i)
+			}
+		} else if len(decoded[i].seq) != 1 || decoded[i].seq[0].varint != uint64(w.seq) {
+			t.Errorf("message %d has seq %v, want %d", i, decoded[i].seq, w.seq)
+		}
+		if len(decoded[i].metrics) != w.metrics {
+			t.Errorf("message %d has %d metrics, want %d", i, len(decoded[i].metrics), w.metrics)
+		}
+	}
+
+	// DBIRTH declares names and aliases, DDATA refers to them by alias only
+	aliases := make(map[uint64]string)
+	for _, metric := range decoded[5].metrics {
+		if len(metric[1]) != 1 || len(metric[2]) != 1 {
+			t.Fatalf("DBIRTH metric without name or alias: %v", metric)
+		}
+		aliases[metric[2][0].varint] = string(metric[1][0].bytes)
+	}
+	if aliases[1] != "A" || aliases[2] != "B" || aliases[3] != "C" {
+		t.Errorf("aliases are %v, want A, B and C numbered from 1", aliases)
+	}
+	for _, metric := range decoded[6].metrics {
+		if _, ok := metric[1]; ok {
+			t.Error("DDATA metric carries a name")
+		}
+		alias := metric[2][0].varint
+		value := math.Float64frombits(metric[13][0].varint)
+		if want := float64(alias - 1); value != want {
+			t.Errorf("%s is %g, want %g", aliases[alias], value, want)
+		}
+	}
+
+	// NBIRTH, NDEATH and the will carry the same bdSeq
+	bdSeq := func(payload []byte) uint64 {
+		metric := byNumber(decode(t, byNumber(decode(t, payload))[2][0].bytes))
+		return metric[11][0].varint
+	}
+	broker.mu.Lock()
+	will := broker.will
+	broker.mu.Unlock()
+	if will.topic != "spBv1.0/g/NDEATH/n" {
+		t.Errorf("will topic is %s", will.topic)
+	}
+	birth := bdSeq(messages[0].payload)
+	if death, w := bdSeq(messages[7].payload), bdSeq(will.payload); death != birth || w != birth {
+		t.Errorf("bdSeq of NBIRTH %d, NDEATH %d and will %d differ", birth, death, w)
+	}
+}
+
+func TestJSONPublish(t *testing.T) {
+	broker := newTestBroker(t)
+	// The datalog times are wall-clock times two hours ahead of UTC
+	zone := time.FixedZone("UTC+2", 2*60*60)
+	p, err := NewPublisher(Options{Broker: broker.url(), ClientID: "test", Format: FormatJSON, TopicPrefix: "plant/", Location: zone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2024, time.January, 2, 10, 0, 0, 250*int(time.Millisecond), time.UTC)
+	records, lookup := testFile([]string{`TEMPERATURES\100`, "A+B#", "NAN"}, []time.Time{t0})
+	records[2].Val = math.NaN()
+	if err := p.WriteRecords(records, lookup); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+
+	messages := broker.wait(t, 2)
+	broker.mu.Lock()
+	if len(broker.messages) != 2 {
+		t.Errorf("published %d messages, the NaN value should be skipped", len(broker.messages))
+	}
+	broker.mu.Unlock()
+	want := []struct {
+		topic  string
+		record jsonRecord
+	}{
+		{"plant/TEMPERATURES/100", jsonRecord{Tag: `TEMPERATURES\100`, Timestamp: "2024-01-02T08:00:00.25Z", Value: 0, Status: "", Marker: ""}},
+		{"plant/A_B_", jsonRecord{Tag: "A+B#", Timestamp: "2024-01-02T08:00:00.25Z", Value: 1, Status: "", Marker: ""}},
+	}
+	for i, m := range messages {
+		if m.topic != want[i].topic {
+			t.Errorf("message %d went to %s, want %s", i, m.topic, want[i].topic)
+		}
+		var got jsonRecord
+		if err := json.Unmarshal(m.payload, &got); err != nil {
+			t.Fatalf("message %d is not JSON: %v", i, err)
+		}
+		if got != want[i].record {
+			t.Errorf("message %d is %+v, want %+v", i, got, want[i].record)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for input, want := range map[string]Format{"json": FormatJSON, "SPB": FormatSparkplugB, "sparkplug": FormatSparkplugB} {
+		if got, err := ParseFormat(input); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %v, %v, want %v", input, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("ParseFormat accepted xml")
+	}
+}
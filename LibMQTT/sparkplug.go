@@ -0,0 +1,92 @@
+package LibMQTT
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Sparkplug B data types used by the publisher
+const (
+	sparkplugUInt64 = 8
+	sparkplugDouble = 10
+)
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+type sparkplugMetric struct {
+	// name is left out of DDATA metrics, they are identified by the alias declared in the DBIRTH
+	name      string
+	alias     uint64
+	timestamp uint64
+	value     float64
+
+	// bdSeq marks the birth/death sequence metric, which is sent as UInt64 and is not historical
+	bdSeq bool
+	// null marks a DBIRTH metric declared without a value
+	null bool
+}
+
+func appendTag(buf []byte, field int, wireType int) []byte {
+	return binary.AppendUvarint(buf, uint64(field<<3|wireType))
+}
+
+func appendBytesField(buf []byte, field int, b []byte) []byte {
+	buf = appendTag(buf, field, wireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func appendVarintField(buf []byte, field int, v uint64) []byte {
+	buf = appendTag(buf, field, wireVarint)
+	return binary.AppendUvarint(buf, v)
+}
+
+// encodeMetric encodes an org.eclipse.tahu.protobuf.Payload.Metric
+func encodeMetric(m sparkplugMetric) []byte {
+	var buf []byte
+	if m.name != "" {
+		buf = appendBytesField(buf, 1, []byte(m.name))
+	}
+	if m.alias != 0 {
+		buf = appendVarintField(buf, 2, m.alias)
+	}
+	buf = appendVarintField(buf, 3, m.timestamp)
+	if m.bdSeq {
+		buf = appendVarintField(buf, 4, sparkplugUInt64)
+		return appendVarintField(buf, 11, uint64(m.value))
+	}
+	buf = appendVarintField(buf, 4, sparkplugDouble)
+	if m.null {
+		return appendVarintField(buf, 7, 1) // is_null
+	}
+	buf = appendVarintField(buf, 5, 1) // is_historical
+	buf = appendTag(buf, 13, wireFixed64)
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(m.value))
+}
+
+// encodePayload encodes an org.eclipse.tahu.protobuf.Payload with a sequence number
+func encodePayload(timestamp uint64, seq uint64, metrics []sparkplugMetric) []byte {
+	var buf []byte
+	buf = appendVarintField(buf, 1, timestamp)
+	for _, m := range metrics {
+		buf = appendBytesField(buf, 2, encodeMetric(m))
+	}
+	return appendVarintField(buf, 3, seq)
+}
+
+// encodeNodeState encodes the NBIRTH and NDEATH payloads, which only carry the bdSeq metric
+func encodeNodeState(timestamp uint64, bdSeq uint64) []byte {
+	var buf []byte
+	buf = appendVarintField(buf, 1, timestamp)
+	return appendBytesField(buf, 2, encodeMetric(sparkplugMetric{
+		name:      "bdSeq",
+		timestamp: timestamp,
+		value:     float64(bdSeq),
+		bdSeq:     true,
+	}))
+}
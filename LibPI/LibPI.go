@@ -1,35 +1,11 @@
 package LibPI
 
-// #include <stdint.h>
-import "C"
 import (
 	"log/slog"
 	"sync"
 	"time"
 )
 
-type PITIMESTAMP struct {
-	Month  C.int
-	Year   C.int
-	Day    C.int
-	Hour   C.int
-	Minute C.int
-	Tzinfo C.int
-	Second C.double
-}
-
-func NewPITIMESTAMP(dt time.Time) PITIMESTAMP {
-	return PITIMESTAMP{
-		Month:  C.int(dt.Month()),
-		Year:   C.int(dt.Year()),
-		Day:    C.int(dt.Day()),
-		Hour:   C.int(dt.Hour()),
-		Minute: C.int(dt.Minute()),
-		Second: C.double(dt.Second()) + C.double(dt.Nanosecond())/1e9,
-		Tzinfo: C.int(0), // Timezone is 0 in datalogs
-	}
-}
-
 // PointType represents the different point types
 type PointType int
 
@@ -61,6 +37,10 @@ type PointCache struct {
 	Process     bool
 	PIName      string
 	PIId        *int32
+	PIType      PointType
+	// Latest is the newest time the historian held for the point before the run, zero when unknown.
+	// Values at or before it are not written again.
+	Latest time.Time
 }
 
 type HistorianPoint struct {
@@ -69,13 +49,18 @@ type HistorianPoint struct {
 
 // Add a method to print out the contents of the PointCache
 func (pc *PointCache) Print() {
+	var piID int32
+	if pc.PIId != nil {
+		piID = *pc.PIId
+	}
 	slog.Debug("PointCache entry",
 		"DatalogName", pc.DatalogName,
 		"DataLogID", pc.DataLogID,
 		"DataLogType", pc.DataLogType,
 		"Process", pc.Process,
 		"PIName", pc.PIName,
-		"PIId", *pc.PIId,
+		"PIId", piID,
+		"PIType", pc.PIType.String(),
 	)
 }
 
@@ -0,0 +1,68 @@
+package LibPI
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// PITimestampSize is the size of a PITIMESTAMP in bytes
+const PITimestampSize = 32
+
+// PITIMESTAMP is laid out like the PITIMESTAMP of piapi.h: six 32 bit integers and the seconds as a
+// double at offset 24. Slices of it are passed to the DLL as they are, so the fields must not change.
+type PITIMESTAMP struct {
+	Month  int32
+	Year   int32
+	Day    int32
+	Hour   int32
+	Minute int32
+	Tzinfo int32
+	Second float64
+}
+
+// NewPITIMESTAMP converts a time, keeping its fraction of a second to the microsecond, so the
+// milliseconds of the datalogs come back unchanged from Time
+func NewPITIMESTAMP(dt time.Time) PITIMESTAMP {
+	return PITIMESTAMP{
+		Month:  int32(dt.Month()),
+		Year:   int32(dt.Year()),
+		Day:    int32(dt.Day()),
+		Hour:   int32(dt.Hour()),
+		Minute: int32(dt.Minute()),
+		Second: float64(dt.Second()) + float64(dt.Nanosecond()/int(time.Microsecond))/1e6,
+		Tzinfo: 0, // Timezone is 0 in datalogs
+	}
+}
+
+// Time converts the timestamp back to a time.Time in UTC. The seconds are rounded to the
+// microsecond, a double doesn't hold most decimal fractions exactly.
+func (ts PITIMESTAMP) Time() time.Time {
+	micros := int64(math.Round(ts.Second * 1e6))
+	return time.Date(int(ts.Year), time.Month(ts.Month), int(ts.Day), int(ts.Hour), int(ts.Minute), 0,
+		int(micros)*int(time.Microsecond), time.UTC)
+}
+
+// MarshalBinary encodes the timestamp as the DLL holds it in memory on little-endian machines
+func (ts PITIMESTAMP) MarshalBinary() ([]byte, error) {
+	b := make([]byte, PITimestampSize)
+	for i, v := range []int32{ts.Month, ts.Year, ts.Day, ts.Hour, ts.Minute, ts.Tzinfo} {
+		binary.LittleEndian.PutUint32(b[i*4:], uint32(v))
+	}
+	binary.LittleEndian.PutUint64(b[24:], math.Float64bits(ts.Second))
+	return b, nil
+}
+
+// UnmarshalBinary decodes a timestamp written by MarshalBinary or the DLL
+func (ts *PITIMESTAMP) UnmarshalBinary(b []byte) error {
+	if len(b) != PITimestampSize {
+		return fmt.Errorf("PITIMESTAMP needs %d bytes, got %d", PITimestampSize, len(b))
+	}
+	fields := []*int32{&ts.Month, &ts.Year, &ts.Day, &ts.Hour, &ts.Minute, &ts.Tzinfo}
+	for i, f := range fields {
+		*f = int32(binary.LittleEndian.Uint32(b[i*4:]))
+	}
+	ts.Second = math.Float64frombits(binary.LittleEndian.Uint64(b[24:]))
+	return nil
+}
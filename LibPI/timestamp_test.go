@@ -0,0 +1,98 @@
+package LibPI
+
+import (
+	"bytes"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestPITimestampLayout(t *testing.T) {
+	var ts PITIMESTAMP
+	if size := unsafe.Sizeof(ts); size != PITimestampSize {
+		t.Fatalf("size is %d, piapi expects %d", size, PITimestampSize)
+	}
+	offsets := []struct {
+		field  string
+		offset uintptr
+		want   uintptr
+	}{
+		{"Month", unsafe.Offsetof(ts.Month), 0},
+		{"Year", unsafe.Offsetof(ts.Year), 4},
+		{"Day", unsafe.Offsetof(ts.Day), 8},
+		{"Hour", unsafe.Offsetof(ts.Hour), 12},
+		{"Minute", unsafe.Offsetof(ts.Minute), 16},
+		{"Tzinfo", unsafe.Offsetof(ts.Tzinfo), 20},
+		{"Second", unsafe.Offsetof(ts.Second), 24},
+	}
+	for _, o := range offsets {
+		if o.offset != o.want {
+			t.Errorf("%s is at offset %d, piapi expects %d", o.field, o.offset, o.want)
+		}
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	ts := NewPITIMESTAMP(time.Date(2024, time.January, 2, 10, 0, 30, 250*int(time.Millisecond), time.UTC))
+	got, err := ts.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		1, 0, 0, 0, // month
+		0xe8, 0x07, 0, 0, // year 2024
+		2, 0, 0, 0, // day
+		10, 0, 0, 0, // hour
+		0, 0, 0, 0, // minute
+		0, 0, 0, 0, // tzinfo
+		0, 0, 0, 0, 0, 0x40, 0x3e, 0x40, // 30.25 as a double
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got % x\nwant % x", got, want)
+	}
+}
+
+// TestMarshalMatchesMemory checks the encoding against the bytes handed to the DLL on this machine
+func TestMarshalMatchesMemory(t *testing.T) {
+	ts := NewPITIMESTAMP(time.Date(1999, time.December, 31, 23, 59, 59, 999*int(time.Millisecond), time.UTC))
+	encoded, _ := ts.MarshalBinary()
+	memory := unsafe.Slice((*byte)(unsafe.Pointer(&ts)), PITimestampSize)
+	if !bytes.Equal(encoded, memory) {
+		t.Fatalf("encoded % x\nmemory  % x", encoded, memory)
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	ts := PITIMESTAMP{Month: 7, Year: 2023, Day: 15, Hour: 8, Minute: 45, Tzinfo: -1, Second: 12.345}
+	b, _ := ts.MarshalBinary()
+	var back PITIMESTAMP
+	if err := back.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if back != ts {
+		t.Fatalf("got %+v, want %+v", back, ts)
+	}
+	if err := back.UnmarshalBinary(b[:31]); err == nil {
+		t.Fatal("expected an error for a short buffer")
+	}
+}
+
+// TestMillisecondRoundTrip checks that every millisecond of the datalog Millitm field survives the double
+func TestMillisecondRoundTrip(t *testing.T) {
+	for _, sec := range []int{0, 1, 30, 59} {
+		for ms := 0; ms < 1000; ms++ {
+			want := time.Date(2024, time.February, 29, 23, 59, sec, ms*int(time.Millisecond), time.UTC)
+			if got := NewPITIMESTAMP(want).Time(); !got.Equal(want) {
+				t.Fatalf("%s came back as %s", want.Format(time.RFC3339Nano), got.Format(time.RFC3339Nano))
+			}
+		}
+	}
+}
+
+func TestTimeRoundsToMicroseconds(t *testing.T) {
+	ts := PITIMESTAMP{Month: 1, Year: 2024, Day: 2, Hour: 10, Minute: 0, Second: 59.9999999}
+	want := time.Date(2024, time.January, 2, 10, 1, 0, 0, time.UTC)
+	if got := ts.Time(); !got.Equal(want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
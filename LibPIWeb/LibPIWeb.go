@@ -0,0 +1,350 @@
+package LibPIWeb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// Writer sends recorded values to a PI Data Archive through PI Web API
+type Writer struct {
+	baseURL    string
+	dataServer string
+	client     *http.Client
+	user       string
+	password   string
+	token      string
+	BatchSize  int
+	// Location is the zone of the datalog wall-clock times, the machine's own zone by default like piapi
+	Location *time.Location
+	// UpdateOption is how values at times that already hold one are written, Insert by default like the fth sink
+	UpdateOption string
+
+	mu     sync.Mutex
+	webIDs map[string]string
+}
+
+func NewWriter(baseURL string, dataServer string) *Writer {
+	return &Writer{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		dataServer:   dataServer,
+		client:       &http.Client{Timeout: 60 * time.Second},
+		BatchSize:    10000,
+		Location:     time.Local,
+		UpdateOption: "Insert",
+		webIDs:       make(map[string]string),
+	}
+}
+
+// updateOptions are the updateOption values of streamsets/recorded
+var updateOptions = []string{"Replace", "Insert", "NoReplace", "ReplaceOnly", "InsertNoCompression"}
+
+// ParseUpdateOption checks a command line value against the update options of PI Web API
+func ParseUpdateOption(s string) (string, error) {
+	for _, option := range updateOptions {
+		if strings.EqualFold(s, option) {
+			return option, nil
+		}
+	}
+	return "", fmt.Errorf("unknown PI Web API update option %q, expected one of %s", s, strings.Join(updateOptions, ", "))
+}
+
+// SetBasicAuth authenticates every request with a user name and password
+func (w *Writer) SetBasicAuth(user string, password string) {
+	w.user = user
+	w.password = password
+}
+
+// SetToken authenticates every request with a bearer token
+func (w *Writer) SetToken(token string) {
+	w.token = token
+}
+
+func (w *Writer) newRequest(method string, u string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+		// PI Web API rejects writes without this header when CSRF defense is enabled
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	}
+	if w.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	} else if w.user != "" {
+		req.SetBasicAuth(w.user, w.password)
+	}
+	return req, nil
+}
+
+// GetWebID resolves a point name to its WebId through the point path \\dataServer\name
+func (w *Writer) GetWebID(ptName string) (string, error) {
+	w.mu.Lock()
+	if webID, ok := w.webIDs[ptName]; ok {
+		w.mu.Unlock()
+		return webID, nil
+	}
+	w.mu.Unlock()
+
+	path := `\\` + w.dataServer + `\` + ptName
+	u := w.baseURL + "/points?selectedFields=WebId&path=" + url.QueryEscape(path)
+	req, err := w.newRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error finding historian point %s: %w", ptName, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error finding historian point %s, PI Web API returned %s: %s", ptName, resp.Status, readMessage(resp.Body))
+	}
+
+	var point struct {
+		WebId string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&point); err != nil {
+		return "", fmt.Errorf("error decoding point %s: %w", ptName, err)
+	}
+	if point.WebId == "" {
+		return "", fmt.Errorf("PI Web API returned no WebId for %s", path)
+	}
+
+	w.mu.Lock()
+	w.webIDs[ptName] = point.WebId
+	w.mu.Unlock()
+	return point.WebId, nil
+}
+
+func (w *Writer) AddToPointCache(datalogName string, datalogID int, datalogType int, piPointName string) *LibPI.PointCache {
+	slog.Debug(fmt.Sprintf("Looking up PI Point %s", piPointName))
+	point := &LibPI.PointCache{
+		DatalogName: datalogName,
+		DataLogID:   datalogID,
+		DataLogType: datalogType,
+		PIName:      piPointName,
+	}
+	if _, err := w.GetWebID(piPointName); err != nil {
+		slog.Error(err.Error())
+		return point
+	}
+	point.Process = true
+	return point
+}
+
+type timedValue struct {
+	Timestamp string
+	Value     float64
+}
+
+type streamValues struct {
+	WebId string
+	Items []timedValue
+	name  string
+	// times are the datalog times of the items
+	times []time.Time
+}
+
+// Failure holds the values of a point that PI Web API rejected in one request
+type Failure struct {
+	Point  string
+	Status int
+	// Code is the PI error code in the message, 0 when the message has none
+	Code    int32
+	Message string
+	Times   []time.Time
+}
+
+// Result accounts for the values of a file sent to PI Web API
+type Result struct {
+	Batches   int
+	Sent      int
+	Succeeded int
+	Failures  []Failure
+}
+
+// Write writes a file's records and reports the values each request failed to write. A request that
+// failed as a whole fails all its values and adds its error to the returned one.
+func (w *Writer) Write(records []*LibDAT.DatFloatRecord, pointLookup *LibPI.PointLookup) (*Result, error) {
+	streams := make(map[string]*streamValues)
+	var order []*streamValues
+	count := 0
+	start := time.Now()
+	result := &Result{}
+
+	var errs []error
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		failures, err := w.postRecorded(order)
+		result.Batches++
+		result.Sent += count
+		result.Succeeded += count
+		for _, f := range failures {
+			result.Succeeded -= len(f.Times)
+		}
+		result.Failures = append(result.Failures, failures...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		streams = make(map[string]*streamValues)
+		order = nil
+		count = 0
+	}
+
+	total := 0
+	for _, record := range records {
+		if record == nil || !record.IsValid {
+			continue
+		}
+		point, exists := pointLookup.GetPointByDataLogID(record.TagID)
+		if !exists || !point.Process {
+			continue
+		}
+		webID, err := w.GetWebID(point.PIName)
+		if err != nil {
+			continue
+		}
+
+		s, ok := streams[webID]
+		if !ok {
+			s = &streamValues{WebId: webID, name: point.PIName}
+			streams[webID] = s
+			order = append(order, s)
+		}
+		s.Items = append(s.Items, timedValue{
+			Timestamp: w.timestamp(record.TimeStamp),
+			Value:     record.Val,
+		})
+		s.times = append(s.times, record.TimeStamp)
+		count++
+		total++
+		if w.BatchSize > 0 && count >= w.BatchSize {
+			flush()
+		}
+	}
+	flush()
+
+	if total < 1 {
+		return result, fmt.Errorf("no valid entries to push to historian")
+	}
+	slog.Info(fmt.Sprintf("Pushed %d records to PI Web API in %.2f seconds", total, time.Since(start).Seconds()))
+	return result, errors.Join(errs...)
+}
+
+// timestamp formats a datalog time for PI Web API. Datalog times are wall-clock times read as UTC,
+// piapi sends the same fields as local time, so they are placed in Location before converting to UTC.
+func (w *Writer) timestamp(t time.Time) string {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), w.Location)
+	return wall.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// postRecorded writes one batch through streamsets/recorded and returns the values it didn't write.
+// When the request fails as a whole every value of the batch is returned with the error.
+func (w *Writer) postRecorded(streams []*streamValues) ([]Failure, error) {
+	failAll := func(status int, err error) ([]Failure, error) {
+		failures := make([]Failure, len(streams))
+		for i, s := range streams {
+			failures[i] = Failure{Point: s.name, Status: status, Code: piErrorCode(err.Error()), Message: err.Error(), Times: s.times}
+		}
+		return failures, err
+	}
+
+	body, err := json.Marshal(streams)
+	if err != nil {
+		return failAll(0, err)
+	}
+
+	query := url.Values{"updateOption": {w.UpdateOption}, "bufferOption": {"BufferIfPossible"}}
+	req, err := w.newRequest(http.MethodPost, w.baseURL+"/streamsets/recorded?"+query.Encode(), body)
+	if err != nil {
+		return failAll(0, err)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return failAll(0, fmt.Errorf("streamsets/recorded failed: %w", err))
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusNoContent:
+		return nil, nil
+	case http.StatusMultiStatus:
+		var result struct {
+			Items []struct {
+				Substatus int
+				Message   string
+				Content   struct {
+					Errors []string
+				}
+			}
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return failAll(resp.StatusCode, fmt.Errorf("streamsets/recorded returned partial failure and an unreadable body: %w", err))
+		}
+		var failures []Failure
+		for i, item := range result.Items {
+			if item.Substatus < 300 || i >= len(streams) {
+				continue
+			}
+			msg := item.Message
+			if len(item.Content.Errors) > 0 {
+				msg = strings.Join(item.Content.Errors, "; ")
+			}
+			failures = append(failures, Failure{Point: streams[i].name, Status: item.Substatus, Code: piErrorCode(msg), Message: msg, Times: streams[i].times})
+		}
+		return failures, nil
+	default:
+		return failAll(resp.StatusCode, fmt.Errorf("streamsets/recorded returned %s: %s", resp.Status, readMessage(resp.Body)))
+	}
+}
+
+// piErrorCode finds the PI error code PI Web API puts in brackets in front of its messages, like [-11046]
+func piErrorCode(msg string) int32 {
+	start := strings.Index(msg, "[-")
+	if start < 0 {
+		return 0
+	}
+	end := strings.IndexByte(msg[start:], ']')
+	if end < 0 {
+		return 0
+	}
+	code, err := strconv.ParseInt(msg[start+1:start+end], 10, 32)
+	if err != nil {
+		return 0
+	}
+	return int32(code)
+}
+
+func readMessage(r io.Reader) string {
+	msg, _ := io.ReadAll(io.LimitReader(r, 1024))
+	var body struct {
+		Errors []string
+	}
+	if json.Unmarshal(msg, &body) == nil && len(body.Errors) > 0 {
+		return strings.Join(body.Errors, "; ")
+	}
+	return strings.TrimSpace(string(msg))
+}
+
+func (w *Writer) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
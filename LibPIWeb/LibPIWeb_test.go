@@ -0,0 +1,248 @@
+package LibPIWeb
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// testServer is a PI Web API stand-in that knows the WebIds of a few points and answers
+// streamsets/recorded with recorded
+type testServer struct {
+	webIDs   map[string]string
+	recorded func(w http.ResponseWriter, streams []streamValues)
+
+	mu       sync.Mutex
+	lookups  []string
+	requests []*http.Request
+	streams  [][]streamValues
+}
+
+func newTestServer(t *testing.T) (*testServer, *Writer) {
+	ts := &testServer{webIDs: map[string]string{`\\pi01\TANK_TEMP`: "P1", `\\pi01\TANK LEVEL`: "P2"}}
+	server := httptest.NewServer(ts)
+	t.Cleanup(server.Close)
+	w := NewWriter(server.URL+"/", "pi01")
+	w.Location = time.UTC
+	return ts, w
+}
+
+func (ts *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	switch r.URL.Path {
+	case "/points":
+		path := r.URL.Query().Get("path")
+		ts.lookups = append(ts.lookups, path)
+		webID, ok := ts.webIDs[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"Errors":["PI Point not found '` + path + `'."]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"WebId": webID})
+	case "/streamsets/recorded":
+		var streams []streamValues
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &streams)
+		ts.requests = append(ts.requests, r)
+		ts.streams = append(ts.streams, streams)
+		if ts.recorded != nil {
+			ts.recorded(w, streams)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestGetWebID(t *testing.T) {
+	ts, w := newTestServer(t)
+
+	for i := 0; i < 2; i++ {
+		webID, err := w.GetWebID("TANK LEVEL")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if webID != "P2" {
+			t.Fatalf("got WebId %s, want P2", webID)
+		}
+	}
+	if len(ts.lookups) != 1 {
+		t.Errorf("looked up the point %d times, the WebId should be cached", len(ts.lookups))
+	}
+	if ts.lookups[0] != `\\pi01\TANK LEVEL` {
+		t.Errorf("looked up path %q", ts.lookups[0])
+	}
+
+	_, err := w.GetWebID("MISSING")
+	if err == nil || !strings.Contains(err.Error(), "PI Point not found") {
+		t.Fatalf("got error %v, want the message of PI Web API", err)
+	}
+	point := w.AddToPointCache("M", 1, 0, "MISSING")
+	if point.Process {
+		t.Error("a point without WebId is processed")
+	}
+}
+
+// testFile builds the records and point lookup of the given points, one value each
+func testFile(w *Writer, names ...string) ([]*LibDAT.DatFloatRecord, *LibPI.PointLookup) {
+	lookup := LibPI.NewPointLookup()
+	var records []*LibDAT.DatFloatRecord
+	for i, name := range names {
+		lookup.AddPoint(w.AddToPointCache(name, i, 0, name))
+		records = append(records, &LibDAT.DatFloatRecord{
+			TimeStamp: time.Date(2024, time.January, 2, 10, 0, 0, 250*int(time.Millisecond), time.UTC),
+			TagID:     i,
+			Val:       float64(i) + 0.5,
+			IsValid:   true,
+		})
+	}
+	return records, lookup
+}
+
+func TestWriteRecorded(t *testing.T) {
+	ts, w := newTestServer(t)
+	records, lookup := testFile(w, "TANK_TEMP", "TANK LEVEL")
+	result, err := w.Write(records, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Batches != 1 || result.Sent != 2 || result.Succeeded != 2 || len(result.Failures) != 0 {
+		t.Errorf("got result %+v, want 2 values written in 1 batch", result)
+	}
+
+	if len(ts.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(ts.requests))
+	}
+	r := ts.requests[0]
+	q := r.URL.Query()
+	if q.Get("updateOption") != "Insert" || q.Get("bufferOption") != "BufferIfPossible" {
+		t.Errorf("posted with query %s", r.URL.RawQuery)
+	}
+	if r.Method != http.MethodPost || r.Header.Get("X-Requested-With") == "" {
+		t.Errorf("posted with method %s and X-Requested-With %q", r.Method, r.Header.Get("X-Requested-With"))
+	}
+	want := []streamValues{
+		{WebId: "P1", Items: []timedValue{{Timestamp: "2024-01-02T10:00:00.250Z", Value: 0.5}}},
+		{WebId: "P2", Items: []timedValue{{Timestamp: "2024-01-02T10:00:00.250Z", Value: 1.5}}},
+	}
+	got := ts.streams[0]
+	if len(got) != len(want) {
+		t.Fatalf("posted %d streams, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].WebId != want[i].WebId || len(got[i].Items) != 1 || got[i].Items[0] != want[i].Items[0] {
+			t.Errorf("stream %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWriteBatches(t *testing.T) {
+	ts, w := newTestServer(t)
+	w.BatchSize = 1
+	w.UpdateOption = "Replace"
+	records, lookup := testFile(w, "TANK_TEMP", "TANK LEVEL")
+	if _, err := w.Write(records, lookup); err != nil {
+		t.Fatal(err)
+	}
+	if len(ts.requests) != 2 {
+		t.Fatalf("got %d requests, want one per value", len(ts.requests))
+	}
+	if option := ts.requests[0].URL.Query().Get("updateOption"); option != "Replace" {
+		t.Errorf("posted with updateOption %s, want Replace", option)
+	}
+}
+
+func TestParseUpdateOption(t *testing.T) {
+	for input, want := range map[string]string{"insert": "Insert", "Replace": "Replace", "noreplace": "NoReplace"} {
+		if got, err := ParseUpdateOption(input); err != nil || got != want {
+			t.Errorf("ParseUpdateOption(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseUpdateOption("Remove"); err == nil {
+		t.Error("Remove is accepted, it would delete the values")
+	}
+}
+
+func TestMultiStatus(t *testing.T) {
+	ts, w := newTestServer(t)
+	ts.recorded = func(rw http.ResponseWriter, streams []streamValues) {
+		rw.WriteHeader(http.StatusMultiStatus)
+		rw.Write([]byte(`{"Items":[
+			{"Substatus":202,"Message":"Accepted"},
+			{"Substatus":409,"Message":"Conflict","Content":{"Errors":["[-11046] Target Date in Future."]}}
+		]}`))
+	}
+	records, lookup := testFile(w, "TANK_TEMP", "TANK LEVEL")
+	result, err := w.Write(records, lookup)
+	if err != nil {
+		t.Fatalf("a partial failure failed the file: %v", err)
+	}
+	if result.Sent != 2 || result.Succeeded != 1 || len(result.Failures) != 1 {
+		t.Fatalf("got result %+v, want 1 of 2 values written", result)
+	}
+	f := result.Failures[0]
+	if f.Point != "TANK LEVEL" || f.Status != 409 || f.Code != -11046 || len(f.Times) != 1 || !strings.Contains(f.Message, "Target Date in Future") {
+		t.Errorf("got failure %+v, want TANK LEVEL rejected with -11046", f)
+	}
+}
+
+func TestRequestFailure(t *testing.T) {
+	ts, w := newTestServer(t)
+	ts.recorded = func(rw http.ResponseWriter, streams []streamValues) {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte(`{"Errors":["The request is invalid."]}`))
+	}
+	records, lookup := testFile(w, "TANK_TEMP", "TANK LEVEL")
+	result, err := w.Write(records, lookup)
+	if err == nil || !strings.Contains(err.Error(), "The request is invalid") {
+		t.Fatalf("got error %v, want the message of PI Web API", err)
+	}
+	if result.Succeeded != 0 || len(result.Failures) != 2 {
+		t.Errorf("got result %+v, want both values failed", result)
+	}
+}
+
+func TestPIErrorCode(t *testing.T) {
+	for msg, want := range map[string]int32{
+		"[-11046] Target Date in Future.": -11046,
+		"Error: [-10401] No Write Access": -10401,
+		"Conflict":                        0,
+		"[-abc] nonsense":                 0,
+		"[-11046 unterminated":            0,
+	} {
+		if got := piErrorCode(msg); got != want {
+			t.Errorf("piErrorCode(%q) = %d, want %d", msg, got, want)
+		}
+	}
+}
+
+func TestTimestampZone(t *testing.T) {
+	w := NewWriter("http://localhost", "pi01")
+	w.Location = time.FixedZone("UTC+2", 2*60*60)
+	wall := time.Date(2024, time.January, 2, 10, 0, 0, 250*int(time.Millisecond), time.UTC)
+	if got, want := w.timestamp(wall), "2024-01-02T08:00:00.250Z"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// The offset follows daylight saving time on the date of the value
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	w.Location = berlin
+	summer := time.Date(2024, time.July, 1, 12, 0, 0, 0, time.UTC)
+	if got, want := w.timestamp(summer), "2024-07-01T10:00:00.000Z"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
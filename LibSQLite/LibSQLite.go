@@ -0,0 +1,308 @@
+package LibSQLite
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// TimeLayout is how timestamps are stored, it sorts correctly and is understood by the SQLite date functions
+const TimeLayout = "2006-01-02 15:04:05.000"
+
+const schema = `
+CREATE TABLE IF NOT EXISTS tags (
+	id           INTEGER PRIMARY KEY,
+	datalog_name TEXT NOT NULL UNIQUE,
+	name         TEXT NOT NULL,
+	datalog_type INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS float_values (
+	tag_id INTEGER NOT NULL REFERENCES tags(id),
+	time   TEXT NOT NULL,
+	value  REAL NOT NULL,
+	status TEXT NOT NULL,
+	marker TEXT NOT NULL,
+	run_id INTEGER NOT NULL REFERENCES import_runs(id)
+);
+CREATE INDEX IF NOT EXISTS float_values_tag_time ON float_values(tag_id, time);
+CREATE TABLE IF NOT EXISTS string_values (
+	tag_id INTEGER NOT NULL REFERENCES tags(id),
+	time   TEXT NOT NULL,
+	value  TEXT NOT NULL,
+	status TEXT NOT NULL,
+	marker TEXT NOT NULL,
+	run_id INTEGER NOT NULL REFERENCES import_runs(id)
+);
+CREATE INDEX IF NOT EXISTS string_values_tag_time ON string_values(tag_id, time);
+CREATE TABLE IF NOT EXISTS import_runs (
+	id          INTEGER PRIMARY KEY,
+	source_dir  TEXT NOT NULL,
+	started_at  TEXT NOT NULL,
+	finished_at TEXT,
+	files       INTEGER NOT NULL DEFAULT 0,
+	floats      INTEGER NOT NULL DEFAULT 0,
+	strings     INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS imported_files (
+	source_dir  TEXT NOT NULL,
+	file_name   TEXT NOT NULL,
+	run_id      INTEGER NOT NULL REFERENCES import_runs(id),
+	floats      INTEGER NOT NULL,
+	strings     INTEGER NOT NULL,
+	imported_at TEXT NOT NULL,
+	PRIMARY KEY (source_dir, file_name)
+);
+`
+
+// Writer stores datalog records in a SQLite database
+type Writer struct {
+	mu        sync.Mutex
+	db        *sql.DB
+	runID     int64
+	sourceDir string
+	tagIDs    map[string]int64
+}
+
+// NewWriter opens or creates the database at dbPath and records the start of an import run for sourceDir
+func NewWriter(dbPath string, sourceDir string) (*Writer, error) {
+	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_synchronous=NORMAL&_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create sqlite schema: %w", err)
+	}
+
+	// Datalog file names repeat across directories, imported files are recorded per source directory
+	if abs, err := filepath.Abs(sourceDir); err == nil {
+		sourceDir = abs
+	}
+	res, err := db.Exec("INSERT INTO import_runs (source_dir, started_at) VALUES (?, ?)",
+		sourceDir, time.Now().UTC().Format(TimeLayout))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to record import run: %w", err)
+	}
+	runID, err := res.LastInsertId()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Writer{db: db, runID: runID, sourceDir: sourceDir, tagIDs: make(map[string]int64)}, nil
+}
+
+// Imported reports whether a DAT file of the source directory was recorded by an earlier run
+func (w *Writer) Imported(fileName string) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var n int
+	err := w.db.QueryRow("SELECT COUNT(*) FROM imported_files WHERE source_dir = ? AND file_name = ?",
+		w.sourceDir, filepath.Base(fileName)).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up imported file: %w", err)
+	}
+	return n > 0, nil
+}
+
+// markImported records a DAT file as complete so later runs skip it. Must be called with w.mu held.
+func (w *Writer) markImported(tx *sql.Tx, fileName string, floats int, strings int) error {
+	_, err := tx.Exec("INSERT OR REPLACE INTO imported_files (source_dir, file_name, run_id, floats, strings, imported_at) VALUES (?, ?, ?, ?, ?, ?)",
+		w.sourceDir, filepath.Base(fileName), w.runID, floats, strings, time.Now().UTC().Format(TimeLayout))
+	if err != nil {
+		return fmt.Errorf("failed to record imported file: %w", err)
+	}
+	_, err = tx.Exec("UPDATE import_runs SET files = files + 1, floats = floats + ?, strings = strings + ? WHERE id = ?",
+		floats, strings, w.runID)
+	return err
+}
+
+// valueRow is one float or string value ready to be stored
+type valueRow struct {
+	point     *LibPI.PointCache
+	timestamp time.Time
+	value     any
+	status    byte
+	marker    byte
+}
+
+// floatRows returns the valid float values of processed points. SQLite stores NaN as NULL, which the
+// value column doesn't take, so NaN and infinite values are left out.
+func floatRows(records []*LibDAT.DatFloatRecord, pointLookup *LibPI.PointLookup) []valueRow {
+	rows := make([]valueRow, 0, len(records))
+	notFinite := 0
+	for _, r := range records {
+		if r == nil || !r.IsValid {
+			continue
+		}
+		point, exists := pointLookup.GetPointByDataLogID(r.TagID)
+		if !exists || !point.Process {
+			continue
+		}
+		if math.IsNaN(r.Val) || math.IsInf(r.Val, 0) {
+			notFinite++
+			continue
+		}
+		rows = append(rows, valueRow{point, r.TimeStamp, r.Val, r.Status, r.Marker})
+	}
+	if notFinite > 0 {
+		slog.Warn(fmt.Sprintf("Skipped %d NaN or infinite values, the database can't hold them", notFinite))
+	}
+	return rows
+}
+
+// stringRows returns the valid string values of processed points
+func stringRows(records []*LibDAT.DatStringRecord, pointLookup *LibPI.PointLookup) []valueRow {
+	rows := make([]valueRow, 0, len(records))
+	for _, r := range records {
+		if r == nil || !r.IsValid {
+			continue
+		}
+		point, exists := pointLookup.GetPointByDataLogID(r.TagID)
+		if !exists || !point.Process {
+			continue
+		}
+		rows = append(rows, valueRow{point, r.TimeStamp, r.Val, r.Status, r.Marker})
+	}
+	return rows
+}
+
+func (w *Writer) WriteRecords(records []*LibDAT.DatFloatRecord, pointLookup *LibPI.PointLookup) error {
+	start := time.Now()
+	rows := floatRows(records, pointLookup)
+	err := w.inTransaction(func(tx *sql.Tx, newTags map[string]int64) error {
+		return w.insert(tx, "float_values", rows, newTags)
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("Stored %d float records in %.2f seconds", len(rows), time.Since(start).Seconds()))
+	return nil
+}
+
+func (w *Writer) WriteStringRecords(records []*LibDAT.DatStringRecord, pointLookup *LibPI.PointLookup) error {
+	start := time.Now()
+	rows := stringRows(records, pointLookup)
+	err := w.inTransaction(func(tx *sql.Tx, newTags map[string]int64) error {
+		return w.insert(tx, "string_values", rows, newTags)
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("Stored %d string records in %.2f seconds", len(rows), time.Since(start).Seconds()))
+	return nil
+}
+
+// StoreFile stores the float and string values of a DAT file and records it as imported in one
+// transaction, so a failed run leaves no values of a file that later runs would store again
+func (w *Writer) StoreFile(fileName string, records []*LibDAT.DatFloatRecord, stringRecords []*LibDAT.DatStringRecord, pointLookup *LibPI.PointLookup) error {
+	start := time.Now()
+	floats, strs := floatRows(records, pointLookup), stringRows(stringRecords, pointLookup)
+	err := w.inTransaction(func(tx *sql.Tx, newTags map[string]int64) error {
+		if err := w.insert(tx, "float_values", floats, newTags); err != nil {
+			return err
+		}
+		if err := w.insert(tx, "string_values", strs, newTags); err != nil {
+			return fmt.Errorf("error writing string values: %w", err)
+		}
+		return w.markImported(tx, fileName, len(records), len(stringRecords))
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("Stored %d float and %d string records in %.2f seconds", len(floats), len(strs), time.Since(start).Seconds()))
+	return nil
+}
+
+// inTransaction runs fn inside a single transaction. Tags fn creates only become visible to other
+// writes once the transaction commits.
+func (w *Writer) inTransaction(fn func(tx *sql.Tx, newTags map[string]int64) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tx, err := w.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	newTags := make(map[string]int64)
+	if err := fn(tx, newTags); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	for name, id := range newTags {
+		w.tagIDs[name] = id
+	}
+	return nil
+}
+
+// insert writes rows into table. Must be called with w.mu held.
+func (w *Writer) insert(tx *sql.Tx, table string, rows []valueRow, newTags map[string]int64) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare("INSERT INTO " + table + " (tag_id, time, value, status, marker, run_id) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		tagID, err := w.tagID(tx, row.point, newTags)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(tagID, row.timestamp.Format(TimeLayout), row.value, string(row.status), string(row.marker), w.runID); err != nil {
+			return fmt.Errorf("failed to insert into %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// tagID returns the row id of a tag, creating it on first use. Must be called with w.mu held.
+func (w *Writer) tagID(tx *sql.Tx, point *LibPI.PointCache, newTags map[string]int64) (int64, error) {
+	if id, ok := w.tagIDs[point.DatalogName]; ok {
+		return id, nil
+	}
+	if id, ok := newTags[point.DatalogName]; ok {
+		return id, nil
+	}
+
+	_, err := tx.Exec(`INSERT INTO tags (datalog_name, name, datalog_type) VALUES (?, ?, ?)
+		ON CONFLICT(datalog_name) DO UPDATE SET name = excluded.name, datalog_type = excluded.datalog_type`,
+		point.DatalogName, point.PIName, point.DataLogType)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store tag %s: %w", point.DatalogName, err)
+	}
+
+	var id int64
+	if err := tx.QueryRow("SELECT id FROM tags WHERE datalog_name = ?", point.DatalogName).Scan(&id); err != nil {
+		return 0, err
+	}
+	newTags[point.DatalogName] = id
+	return id, nil
+}
+
+// Close marks the import run as finished and closes the database
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.db.Exec("UPDATE import_runs SET finished_at = ? WHERE id = ?", time.Now().UTC().Format(TimeLayout), w.runID); err != nil {
+		slog.Error(fmt.Sprintf("Failed to record end of import run: %v", err))
+	}
+	return w.db.Close()
+}
@@ -0,0 +1,60 @@
+package LibSQLite
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// testFile builds the records and point lookup of one tag with the given values, a second apart
+func testFile(values ...float64) ([]*LibDAT.DatFloatRecord, *LibPI.PointLookup) {
+	lookup := LibPI.NewPointLookup()
+	lookup.AddPoint(&LibPI.PointCache{DatalogName: "A", DataLogID: 1, Process: true, PIName: "A"})
+	start := time.Date(2024, time.January, 2, 10, 0, 0, 0, time.UTC)
+	records := make([]*LibDAT.DatFloatRecord, len(values))
+	for i, v := range values {
+		records[i] = &LibDAT.DatFloatRecord{TimeStamp: start.Add(time.Duration(i) * time.Second), TagID: 1, Val: v, Status: ' ', Marker: ' ', IsValid: true}
+	}
+	return records, lookup
+}
+
+func TestFloatRowsSkipNotFinite(t *testing.T) {
+	records, lookup := testFile(1, math.NaN(), math.Inf(1), math.Inf(-1), 2)
+	rows := floatRows(records, lookup)
+	if len(rows) != 2 {
+		t.Fatalf("kept %d rows, want 2", len(rows))
+	}
+}
+
+func TestStoreFileWithNaN(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(filepath.Join(dir, "history.db"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	records, lookup := testFile(1, math.NaN(), 2)
+	if err := w.StoreFile(filepath.Join(dir, "2024 01 02 0000 (Float).DAT"), records, nil, lookup); err != nil {
+		t.Fatalf("a NaN value failed the file: %v", err)
+	}
+
+	var stored int
+	if err := w.db.QueryRow("SELECT COUNT(*) FROM float_values").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 2 {
+		t.Errorf("stored %d values, want 2", stored)
+	}
+	imported, err := w.Imported("2024 01 02 0000 (Float).DAT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !imported {
+		t.Error("the file was not recorded as imported")
+	}
+}
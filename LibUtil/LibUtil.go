@@ -33,6 +33,10 @@ func LoadTagMapCSV(filePath string, tagMap map[string]string) error {
 			continue
 		}
 
+		// Rows without a historian tag, like the unmatched rows of a proposed map, are left out
+		if strings.TrimSpace(record[1]) == "" {
+			continue
+		}
 		tagMap[strings.ToUpper(record[0])] = record[1]
 	}
 
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibFTH"
+)
+
+// pointTemplate holds attribute values for new points, per PI pointtype or * for all of them
+type pointTemplate map[string]map[string]string
+
+// defaultPointTemplate is used for attributes the template file doesn't set
+var defaultPointTemplate = pointTemplate{
+	"*": {"pointsource": "L", "compressing": "1", "compdevpercent": "0.1", "excdev": "0"},
+}
+
+// loadPointTemplateCSV reads rows of pointtype (or *), attribute and value into template
+func loadPointTemplateCSV(filePath string, template pointTemplate) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	for i := 0; ; i++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading CSV file at line %d: %w", i+1, err)
+		}
+		if len(record) < 3 {
+			continue
+		}
+		pointType := strings.ToLower(strings.TrimSpace(record[0]))
+		if template[pointType] == nil {
+			template[pointType] = make(map[string]string)
+		}
+		template[pointType][strings.ToLower(strings.TrimSpace(record[1]))] = strings.TrimSpace(record[2])
+	}
+}
+
+// newPointAttributes fills in the attributes of a point to create for a datalog point. The pointtype
+// follows the datalog tag type, zero and span cover the datalog values, the template sets the rest.
+func newPointAttributes(point *datalogPoint, template pointTemplate) map[string]string {
+	attrs := map[string]string{
+		"tag":        point.PIName,
+		"pointtype":  "float32",
+		"descriptor": "Datalog tag " + point.DatalogNames[0],
+		"step":       "0",
+	}
+	if point.TagType == LibDAT.TagTypeDigital {
+		attrs["pointtype"] = "digital"
+		attrs["step"] = "1"
+	} else if point.Count > 0 {
+		zero := math.Floor(point.Min)
+		attrs["zero"] = formatFloat(zero)
+		attrs["span"] = formatFloat(max(math.Ceil(point.Max)-zero, 1))
+	}
+
+	for _, key := range []string{"*", attrs["pointtype"]} {
+		for _, t := range []pointTemplate{defaultPointTemplate, template} {
+			for name, value := range t[key] {
+				attrs[name] = value
+			}
+		}
+	}
+	attrs["tag"] = point.PIName
+	return attrs
+}
+
+// attributeColumns orders the attributes of all points, tag and pointtype first
+func attributeColumns(points []map[string]string) []string {
+	seen := map[string]bool{"tag": true, "pointtype": true}
+	var rest []string
+	for _, attrs := range points {
+		for name := range attrs {
+			if !seen[name] {
+				seen[name] = true
+				rest = append(rest, name)
+			}
+		}
+	}
+	sort.Strings(rest)
+	return append([]string{"tag", "pointtype"}, rest...)
+}
+
+// writePiconfigScript writes a piconfig input file that creates the points
+func writePiconfigScript(w io.Writer, points []map[string]string) error {
+	columns := attributeColumns(points)
+	fmt.Fprintln(w, "* Points missing for the datalog import, review before running: piconfig < this file")
+	fmt.Fprintln(w, "@table pipoint")
+	fmt.Fprintln(w, "@ptclass classic")
+	fmt.Fprintln(w, "@mode create,t")
+	fmt.Fprintf(w, "@istr %s\n", strings.Join(columns, ","))
+	for _, attrs := range points {
+		values := make([]string, len(columns))
+		for i, name := range columns {
+			values[i] = piconfigValue(attrs[name])
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(values, ",")); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "@ends")
+	return err
+}
+
+// piconfigValue quotes values that piconfig would otherwise split
+func piconfigValue(v string) string {
+	if strings.ContainsAny(v, ", \"") {
+		return `"` + strings.ReplaceAll(v, `"`, `""`) + `"`
+	}
+	return v
+}
+
+// writeBuilderCSV writes the points in the layout of a PI Builder PI point sheet
+func writeBuilderCSV(w io.Writer, server string, points []map[string]string) error {
+	columns := attributeColumns(points)[1:]
+	out := csv.NewWriter(w)
+	out.Write(append([]string{"Selected(x)", "Parent", "Name", "ObjectType"}, columns...))
+	for _, attrs := range points {
+		row := []string{"x", server, attrs["tag"], "PIPoint"}
+		for _, name := range columns {
+			row = append(row, attrs[name])
+		}
+		out.Write(row)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// runCreatePoints looks up every mapped point and writes a piconfig script or PI Builder CSV that
+// creates the ones the historian doesn't have. The historian is not changed.
+func runCreatePoints(dr *LibDAT.DatReader, tagMaps map[string]string, useTagMap bool, opts sinkOptions, format string, outPath string, templatePath string) error {
+	template := pointTemplate{}
+	if templatePath != "" {
+		if err := loadPointTemplateCSV(templatePath, template); err != nil {
+			return fmt.Errorf("failed to load point template: %w", err)
+		}
+	}
+	if format != "piconfig" && format != "builder" {
+		return fmt.Errorf("unknown point file format %q, expected piconfig or builder", format)
+	}
+
+	points, err := scanDatalogs(dr, tagMaps, useTagMap, true)
+	if err != nil {
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("Connecting to piserver at: %s, with process name %s", opts.host, opts.processName))
+	LibFTH.SetProcessName(opts.processName)
+	if err := LibFTH.Connect(opts.host); err != nil {
+		logHint(err, opts.processName)
+		return err
+	}
+	defer LibFTH.Disconnect()
+
+	var missing []map[string]string
+	for _, point := range points {
+		if len(point.PIName) > 80 {
+			slog.Warn(fmt.Sprintf("%s is longer than 80 characters, the import can't write it, it is left out", point.PIName))
+			continue
+		}
+		_, err := LibFTH.GetPointNumber(point.PIName)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, LibFTH.ErrPointLevel) {
+			return err
+		}
+		if point.TagType == LibDAT.TagTypeString {
+			slog.Warn(fmt.Sprintf("%s is missing, but string tags are not imported, it is left out", point.PIName))
+			continue
+		}
+		attrs := newPointAttributes(point, template)
+		if attrs["pointtype"] == "digital" && attrs["digitalset"] == "" {
+			slog.Warn(fmt.Sprintf("%s will be a digital point, add a digitalset to the template for digital points", point.PIName))
+		}
+		missing = append(missing, attrs)
+	}
+	if len(missing) == 0 {
+		slog.Info(fmt.Sprintf("All %d mapped points exist, nothing to create", len(points)))
+		return nil
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i]["tag"] < missing[j]["tag"] })
+
+	file, err := os.Create(outPath)
+	if err != nil {
+		return fmt.Errorf("failed to create point file: %w", err)
+	}
+	defer file.Close()
+	if format == "piconfig" {
+		err = writePiconfigScript(file, missing)
+	} else {
+		err = writeBuilderCSV(file, opts.host, missing)
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("%d of %d mapped points are missing, written to %s for review", len(missing), len(points), outPath))
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+)
+
+func TestWritePiconfigScript(t *testing.T) {
+	template := pointTemplate{
+		"*":       {"pointsource": "D", "tag": "IGNORED"},
+		"float32": {"engunits": "m3/h", "compdevpercent": "0.5"},
+		"digital": {"digitalset": "modes"},
+	}
+	points := []*datalogPoint{
+		{PIName: "TANK TEMP,A", DatalogNames: []string{`TEMPERATURES\100`}, TagType: LibDAT.TagTypeAnalog, Count: 3, Min: -1.5, Max: 98.2},
+		{PIName: `VALVE "1"`, DatalogNames: []string{`STATE\TXT`}, TagType: LibDAT.TagTypeDigital, Count: 2, Min: 0, Max: 1},
+		{PIName: "FLOW_1", DatalogNames: []string{"FLOW_1", "FLOW1"}, TagType: LibDAT.TagTypeAnalog},
+	}
+	var attrs []map[string]string
+	for _, point := range points {
+		attrs = append(attrs, newPointAttributes(point, template))
+	}
+
+	var out bytes.Buffer
+	if err := writePiconfigScript(&out, attrs); err != nil {
+		t.Fatal(err)
+	}
+	want := `* Points missing for the datalog import, review before running: piconfig < this file
+@table pipoint
+@ptclass classic
+@mode create,t
+@istr tag,pointtype,compdevpercent,compressing,descriptor,digitalset,engunits,excdev,pointsource,span,step,zero
+"TANK TEMP,A",float32,0.5,1,"Datalog tag TEMPERATURES\100",,m3/h,0,D,101,0,-2
+"VALVE ""1""",digital,0.1,1,"Datalog tag STATE\TXT",modes,,0,D,,1,
+FLOW_1,float32,0.5,1,"Datalog tag FLOW_1",,m3/h,0,D,,0,
+@ends
+`
+	if got := out.String(); got != want {
+		t.Errorf("wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPiconfigValue(t *testing.T) {
+	tests := []struct {
+		v    string
+		want string
+	}{
+		{"TIC101", "TIC101"},
+		{"", ""},
+		{"TANK TEMP", `"TANK TEMP"`},
+		{"A,B", `"A,B"`},
+		{`say "hi"`, `"say ""hi"""`},
+		{`TEMPERATURES\100`, `TEMPERATURES\100`},
+	}
+	for _, tt := range tests {
+		if got := piconfigValue(tt.v); got != tt.want {
+			t.Errorf("piconfigValue(%q) = %s, want %s", tt.v, got, tt.want)
+		}
+	}
+}
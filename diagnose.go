@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/complacentsee/goDatalogConvert/LibFTH"
+	"golang.org/x/term"
+)
+
+// readPassword takes the password for -piUser from the environment variable, or asks for it on the
+// terminal without echoing it when prompt is set. The variable is set afterwards, so worker
+// processes find it there.
+func readPassword(envName string, user string, prompt bool) (string, error) {
+	if password, ok := os.LookupEnv(envName); ok {
+		return password, nil
+	}
+	if !prompt {
+		return "", fmt.Errorf("no password for %s in %s", user, envName)
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("no password for %s in %s, and stdin is not a terminal to ask for it", user, envName)
+	}
+	fmt.Fprintf(os.Stderr, "Password for %s: ", user)
+	line, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	password := string(line)
+	os.Setenv(envName, password)
+	return password, nil
+}
+
+// connectionHint names the likely cause of a historian error, so users don't have to look up the code
+func connectionHint(err error, processName string) string {
+	var piErr *LibFTH.PIError
+	if !errors.As(err, &piErr) {
+		return ""
+	}
+	switch {
+	case piErr.Code <= -10400 && piErr.Code > -10500:
+		if _, ok := LibFTH.LoginAccess(); ok {
+			return "The server denied access to the user given with -piUser, check its identity mappings and the data security of the points."
+		}
+		return fmt.Sprintf("No trust grants this connection the access it needs. In SMT > Security > Mappings & Trusts add a trust "+
+			"for the address of this machine and the process name %s, mapped to an identity with write access to the points, "+
+			"or log in with -piUser.", processName)
+	case piErr.Class == LibFTH.ErrorClassRetryable:
+		return "The server could not be reached, check the host name, that port 5450 is open and that the PI Network Manager runs."
+	case piErr.Class == LibFTH.ErrorClassPoint:
+		return "The point was not found, check its name in the tag map."
+	}
+	return ""
+}
+
+// runDiagnose connects like an import would and reports the access it gets. With a point it checks
+// read access by reading its snapshot, and with write it also writes a test value to the point.
+func runDiagnose(host string, processName string, point string, write bool) error {
+	slog.Info(fmt.Sprintf("Connecting to piserver at: %s, with process name %s", host, processName))
+	LibFTH.SetProcessName(processName)
+	if err := LibFTH.Connect(host); err != nil {
+		logHint(err, processName)
+		return err
+	}
+	defer LibFTH.Disconnect()
+
+	source := fmt.Sprintf("the trust matching this machine and process name %s", processName)
+	if access, ok := LibFTH.LoginAccess(); ok {
+		source = "the -piUser login"
+		slog.Info(fmt.Sprintf("Logged in with piut_login, access level: %s", access))
+		if access != LibFTH.AccessReadWrite {
+			slog.Warn("The login doesn't allow writes, values will be rejected")
+		}
+	} else {
+		slog.Info(fmt.Sprintf("No -piUser given, access comes from %s", source))
+	}
+
+	if err := LibFTH.CheckConnection(); err != nil {
+		logHint(err, processName)
+		return fmt.Errorf("connected, but the server doesn't answer: %w", err)
+	}
+	slog.Info("Server answers requests")
+
+	if point == "" {
+		slog.Info("Add -diagnosePoint to check the access to a point")
+		return nil
+	}
+
+	ptNumber, err := LibFTH.GetPointNumber(point)
+	if err != nil {
+		logHint(err, processName)
+		return err
+	}
+	pointType, err := LibFTH.GetPointType(ptNumber)
+	if err != nil {
+		logHint(err, processName)
+		return err
+	}
+	slog.Info(fmt.Sprintf("Found point %s, id %d, type %s", point, ptNumber, pointType))
+
+	if _, err := LibFTH.Snapshot(ptNumber); err != nil {
+		logHint(err, processName)
+		return fmt.Errorf("%s gives no read access to the data of %s: %w", source, point, err)
+	}
+
+	if !write {
+		slog.Info(fmt.Sprintf("Access to %s through %s: read, write not tested. Add -diagnoseWrite to write a test value", point, source))
+		return nil
+	}
+	if err := LibFTH.WriteTestValue(ptNumber); err != nil {
+		logHint(err, processName)
+		return fmt.Errorf("%s gives read access to %s, but a test write failed: %w", source, point, err)
+	}
+	slog.Info(fmt.Sprintf("Access to %s through %s: read/write, wrote the snapshot value again at the current time", point, source))
+	return nil
+}
+
+func logHint(err error, processName string) {
+	if hint := connectionHint(err, processName); hint != "" {
+		slog.Warn(hint)
+	}
+}
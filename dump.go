@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+)
+
+// dumpTag is the JSON Lines form of a (Tagname) record
+type dumpTag struct {
+	Type        string `json:"type"`
+	File        string `json:"file"`
+	TagID       int    `json:"tagId"`
+	Tag         string `json:"tag"`
+	MappedTag   string `json:"mappedTag,omitempty"`
+	TagType     int    `json:"tagType"`
+	TagDataType int    `json:"tagDataType"`
+}
+
+// dumpValue is the JSON Lines form of a (Float) or (String) record
+type dumpValue struct {
+	Type      string   `json:"type"`
+	File      string   `json:"file"`
+	Time      string   `json:"time,omitempty"`
+	TagID     int      `json:"tagId"`
+	Tag       string   `json:"tag,omitempty"`
+	MappedTag string   `json:"mappedTag,omitempty"`
+	Value     *float64 `json:"value,omitempty"`
+	String    *string  `json:"string,omitempty"`
+	Status    string   `json:"status"`
+	RawStatus string   `json:"rawStatus"`
+	Marker    string   `json:"marker,omitempty"`
+	Valid     bool     `json:"valid"`
+}
+
+// runDump writes every decoded record of the datalogs in dirPath to out as JSON Lines, with the
+// times in zone
+func runDump(out io.Writer, dirPath string, tagMaps map[string]string, zone *time.Location) error {
+	dr, err := LibDAT.NewDatReader(dirPath)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(out)
+	defer bw.Flush()
+	enc := json.NewEncoder(bw)
+
+	for _, fileName := range dr.GetFloatFiles() {
+		base := filepath.Base(fileName)
+
+		tags, err := dr.ReadTagFile(fileName)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error reading tag file for %s: %v", fileName, err))
+			continue
+		}
+		names := make(map[int]string, len(tags))
+		for _, tag := range tags {
+			names[tag.ID] = tag.Name
+			if err := enc.Encode(dumpTag{
+				Type:        "tag",
+				File:        base,
+				TagID:       tag.ID,
+				Tag:         tag.Name,
+				MappedTag:   tagMaps[strings.ToUpper(tag.Name)],
+				TagType:     tag.Type,
+				TagDataType: tag.Dtype,
+			}); err != nil {
+				return err
+			}
+		}
+
+		records, err := dr.ReadFloatFile(fileName)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error reading float file for %s: %v", fileName, err))
+			continue
+		}
+		for _, record := range records {
+			if record == nil {
+				continue
+			}
+			v := newDumpValue("float", base, record.TimeStamp, record.TagID, record.Status, record.Marker, record.IsValid, names, tagMaps, zone)
+			// JSON has no NaN or infinity, those values are written without a value
+			if !math.IsNaN(record.Val) && !math.IsInf(record.Val, 0) {
+				val := record.Val
+				v.Value = &val
+			}
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+		}
+
+		stringRecords, err := dr.ReadStringFile(fileName)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				slog.Error(fmt.Sprintf("Error reading string file for %s: %v", fileName, err))
+			}
+			continue
+		}
+		for _, record := range stringRecords {
+			v := newDumpValue("string", strings.Replace(base, " (Float)", " (String)", 1), record.TimeStamp, record.TagID, record.Status, record.Marker, record.IsValid, names, tagMaps, zone)
+			val := record.Val
+			v.String = &val
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func newDumpValue(typ string, file string, ts time.Time, tagID int, status byte, marker byte, valid bool, names map[int]string, tagMaps map[string]string, zone *time.Location) dumpValue {
+	v := dumpValue{
+		Type:      typ,
+		File:      file,
+		TagID:     tagID,
+		Tag:       names[tagID],
+		MappedTag: tagMaps[strings.ToUpper(names[tagID])],
+		Status:    LibDAT.NewStatus(status).String(),
+		RawStatus: strings.Trim(string(status), "\x00"),
+		Marker:    LibDAT.NewMarker(marker).String(),
+		Valid:     valid,
+	}
+	if !ts.IsZero() {
+		// Datalog times are wall-clock times read as UTC, print them with the offset of zone
+		wall := time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), zone)
+		v.Time = wall.Format("2006-01-02T15:04:05.000Z07:00")
+	}
+	return v
+}
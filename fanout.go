@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibFTH"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// failedBatch is a line of the failed batch journal: values of a point in a file that a server
+// didn't take. A later run with -replayFailed sends exactly these values to that server again,
+// and marks the line replayed once the server took all of them.
+type failedBatch struct {
+	Server   string      `json:"server"`
+	File     string      `json:"file"`
+	Point    string      `json:"point"`
+	First    time.Time   `json:"first"`
+	Last     time.Time   `json:"last"`
+	Failed   int         `json:"failed"`
+	Code     int32       `json:"code,omitempty"`
+	Times    []time.Time `json:"times"`
+	Replayed bool        `json:"replayed,omitempty"`
+}
+
+// covers reports whether the value of the point at ts is one of the entry's values
+func (b *failedBatch) covers(piName string, ts time.Time) bool {
+	if !strings.EqualFold(b.Point, piName) {
+		return false
+	}
+	for _, t := range b.Times {
+		if sameTime(t, ts) {
+			return true
+		}
+	}
+	return false
+}
+
+// replayJournal is a failed batch journal being replayed. Entries are indexed by server and file
+// name, lines that were replayed before are kept in the file but not sent again.
+type replayJournal struct {
+	path    string
+	entries []*failedBatch
+	pending map[string]map[string][]*failedBatch
+}
+
+// loadFailedBatches reads a failed batch journal
+func loadFailedBatches(path string) (*replayJournal, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer f.Close()
+
+	j := &replayJournal{path: path, pending: make(map[string]map[string][]*failedBatch)}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		b := &failedBatch{}
+		if err := json.Unmarshal(scanner.Bytes(), b); err != nil {
+			return nil, fmt.Errorf("error reading journal at line %d: %w", line, err)
+		}
+		j.entries = append(j.entries, b)
+		if b.Replayed {
+			continue
+		}
+		if j.pending[b.Server] == nil {
+			j.pending[b.Server] = make(map[string][]*failedBatch)
+		}
+		j.pending[b.Server][b.File] = append(j.pending[b.Server][b.File], b)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading journal: %w", err)
+	}
+	return j, nil
+}
+
+// markReplayed marks the entries of a file on a server replayed, except for the points that failed again
+func (j *replayJournal) markReplayed(server string, file string, failed map[string]bool) {
+	for _, entry := range j.pending[server][file] {
+		if !failed[strings.ToUpper(entry.Point)] {
+			entry.Replayed = true
+		}
+	}
+}
+
+// save writes the journal back with the replayed marks, through a temporary file so a crash leaves the old one
+func (j *replayJournal) save() error {
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save journal: %w", err)
+	}
+	bw := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(bw)
+	for _, entry := range j.entries {
+		if err := enc.Encode(entry); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return fmt.Errorf("failed to save journal: %w", err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to save journal: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to save journal: %w", err)
+	}
+	return os.Rename(tmp.Name(), j.path)
+}
+
+// fanoutWriter writes every file to several historian servers. Each server has its own worker
+// processes and results, and the values a server didn't take are added to a journal.
+type fanoutWriter struct {
+	hosts   []string
+	servers []*shardedWriter
+	// replay limits each server to its entries of an earlier journal, nil writes everything
+	replay *replayJournal
+
+	mu      sync.Mutex
+	journal *os.File
+	enc     *json.Encoder
+}
+
+func newFanoutWriter(hosts []string, workers int, processName string, journalPath string, replay *replayJournal) (*fanoutWriter, error) {
+	journal, err := os.OpenFile(journalPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	w := &fanoutWriter{hosts: hosts, replay: replay, journal: journal, enc: json.NewEncoder(journal)}
+	for _, host := range hosts {
+		sw, err := newShardedWriter(max(workers, 1), host, processName, host)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w.servers = append(w.servers, sw)
+	}
+	return w, nil
+}
+
+func (*fanoutWriter) writesInOrder() {}
+
+func (*fanoutWriter) ResolvePoint(tag *LibDAT.DatTagRecord, targetName string) *LibPI.PointCache {
+	return namedPoint(tag, targetName)
+}
+
+// Selects limits a replay run to the files in the journal of one of the servers
+func (w *fanoutWriter) Selects(fileName string) bool {
+	if w.replay == nil {
+		return true
+	}
+	for _, host := range w.hosts {
+		if _, ok := w.replay.pending[host][filepath.Base(fileName)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteFile writes the file to all servers at once, a failure on one server doesn't stop the others
+func (w *fanoutWriter) WriteFile(file datRecord) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(w.servers))
+	for i, sw := range w.servers {
+		host := w.hosts[i]
+		records := file.Records
+		if w.replay != nil {
+			records = replayRecords(file, w.replay.pending[host][filepath.Base(file.FileName)])
+			if len(records) == 0 {
+				continue
+			}
+		}
+
+		wg.Add(1)
+		go func(i int, sw *shardedWriter, file datRecord) {
+			defer wg.Done()
+			result, lost, err := sw.writeFile(file)
+			failed := w.record(host, filepath.Base(file.FileName), result, lost)
+			if w.replay != nil {
+				w.mu.Lock()
+				w.replay.markReplayed(host, filepath.Base(file.FileName), failed)
+				w.mu.Unlock()
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", host, err)
+			}
+		}(i, sw, datRecord{FileName: file.FileName, Records: records, PointLookup: file.PointLookup, Seq: file.Seq})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// replayRecords keeps the values of a file that the journal entries cover
+func replayRecords(file datRecord, entries []*failedBatch) []*LibDAT.DatFloatRecord {
+	var records []*LibDAT.DatFloatRecord
+	for _, record := range file.Records {
+		if record == nil {
+			continue
+		}
+		point, exists := file.PointLookup.GetPointByDataLogID(record.TagID)
+		if !exists {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.covers(point.PIName, record.TimeStamp) {
+				records = append(records, record)
+				break
+			}
+		}
+	}
+	return records
+}
+
+// record adds the values a server rejected, and the batches of workers that didn't answer, to the
+// journal. It returns the upper case names of the points with values in the journal.
+func (w *fanoutWriter) record(host string, file string, result *LibFTH.WriteResult, lost []*shardBatch) map[string]bool {
+	entries := journalEntries(host, file, result, lost)
+	failed := make(map[string]bool)
+	for _, entry := range entries {
+		failed[strings.ToUpper(entry.Point)] = true
+	}
+	if len(entries) == 0 {
+		return failed
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, entry := range entries {
+		if err := w.enc.Encode(entry); err != nil {
+			slog.Error(fmt.Sprintf("Error writing failed batch journal: %v", err))
+			return failed
+		}
+	}
+	slog.Warn(fmt.Sprintf("%s: %s: %d failed point batches added to the journal", host, file, len(entries)))
+	return failed
+}
+
+// journalEntries lists the values of a file a server didn't take, per point and error code
+func journalEntries(host string, file string, result *LibFTH.WriteResult, lost []*shardBatch) []failedBatch {
+	var entries []failedBatch
+	for _, f := range result.SortedFailures() {
+		entries = append(entries, failedBatch{Server: host, File: file, Point: f.PIName, First: f.First, Last: f.Last, Failed: f.Count, Code: f.Code, Times: f.Times})
+	}
+	for _, batch := range lost {
+		names := make(map[int]string, len(batch.Points))
+		for _, p := range batch.Points {
+			names[p.TagID] = p.PIName
+		}
+		byPoint := make(map[string]*failedBatch)
+		var order []string
+		for _, r := range batch.Records {
+			name := names[r.TagID]
+			entry, ok := byPoint[name]
+			if !ok {
+				entry = &failedBatch{Server: host, File: file, Point: name, First: r.Time, Last: r.Time}
+				byPoint[name] = entry
+				order = append(order, name)
+			}
+			entry.Failed++
+			entry.Times = append(entry.Times, r.Time)
+			if r.Time.Before(entry.First) {
+				entry.First = r.Time
+			}
+			if r.Time.After(entry.Last) {
+				entry.Last = r.Time
+			}
+		}
+		for _, name := range order {
+			entries = append(entries, *byPoint[name])
+		}
+	}
+	return entries
+}
+
+// Report logs the run totals of every server
+func (w *fanoutWriter) Report() {
+	for _, sw := range w.servers {
+		sw.Report()
+	}
+}
+
+func (w *fanoutWriter) Close() error {
+	var errs []error
+	for _, sw := range w.servers {
+		errs = append(errs, sw.Close())
+	}
+	if w.journal != nil {
+		errs = append(errs, w.journal.Close())
+	}
+	if w.replay != nil {
+		errs = append(errs, w.replay.save())
+	}
+	return errors.Join(errs...)
+}
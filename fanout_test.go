@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibFTH"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+func TestJournalRoundTrip(t *testing.T) {
+	const file = "2024 01 02 0000 (Float).DAT"
+	t0 := time.Date(2024, time.January, 2, 10, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return t0.Add(time.Duration(seconds) * time.Second) }
+
+	// TIC101 was rejected at 0s and 2s but took the value at 1s in between, the batch of TIC102 was lost
+	result := LibFTH.NewWriteResult()
+	result.AddFailure(1, -11049, at(0))
+	result.AddFailure(1, -11049, at(2))
+	for _, f := range result.Failures {
+		f.PIName = "TIC101"
+	}
+	lost := []*shardBatch{{
+		File:    file,
+		Points:  []shardPoint{{TagID: 2, PIName: "TIC102"}},
+		Records: []shardRecord{{TagID: 2, Time: at(1)}},
+	}}
+
+	path := filepath.Join(t.TempDir(), "failed_batches.jsonl")
+	journal, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &fanoutWriter{journal: journal, enc: json.NewEncoder(journal)}
+	failed := w.record("hist-b", file, result, lost)
+	journal.Close()
+	if !failed["TIC101"] || !failed["TIC102"] || len(failed) != 2 {
+		t.Errorf("record reported failed points %v", failed)
+	}
+
+	replay, err := loadFailedBatches(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := replay.pending["hist-b"][file]
+	if len(entries) != 2 {
+		t.Fatalf("loaded %d entries, want 2", len(entries))
+	}
+
+	lookup := LibPI.NewPointLookup()
+	lookup.AddPoint(&LibPI.PointCache{DataLogID: 1, PIName: "TIC101", Process: true})
+	lookup.AddPoint(&LibPI.PointCache{DataLogID: 2, PIName: "TIC102", Process: true})
+	var records []*LibDAT.DatFloatRecord
+	for s := 0; s < 3; s++ {
+		for id := 1; id <= 2; id++ {
+			records = append(records, &LibDAT.DatFloatRecord{TimeStamp: at(s), TagID: id, IsValid: true})
+		}
+	}
+	got := replayRecords(datRecord{FileName: file, Records: records, PointLookup: lookup}, entries)
+	want := []struct {
+		tagID int
+		time  time.Time
+	}{{1, at(0)}, {2, at(1)}, {1, at(2)}}
+	if len(got) != len(want) {
+		t.Fatalf("replays %d values, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].TagID != want[i].tagID || !got[i].TimeStamp.Equal(want[i].time) {
+			t.Errorf("value %d is tag %d at %s, want tag %d at %s", i, got[i].TagID, got[i].TimeStamp, want[i].tagID, want[i].time)
+		}
+	}
+
+	// TIC102 failed again, so only TIC101 is marked replayed
+	replay.markReplayed("hist-b", file, map[string]bool{"TIC102": true})
+	if err := replay.save(); err != nil {
+		t.Fatal(err)
+	}
+	again, err := loadFailedBatches(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.entries) != 2 {
+		t.Errorf("the saved journal has %d lines, want 2", len(again.entries))
+	}
+	pending := again.pending["hist-b"][file]
+	if len(pending) != 1 || pending[0].Point != "TIC102" {
+		t.Errorf("pending after the replay: %+v, want only TIC102", pending)
+	}
+}
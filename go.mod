@@ -1,3 +1,16 @@
 module github.com/complacentsee/goDatalogConvert
 
 go 1.21
+
+require (
+	github.com/eclipse/paho.mqtt.golang v1.4.3
+	github.com/mattn/go-sqlite3 v1.14.22
+	golang.org/x/term v0.20.0
+)
+
+require (
+	github.com/gorilla/websocket v1.5.0 // indirect
+	golang.org/x/net v0.8.0 // indirect
+	golang.org/x/sync v0.1.0 // indirect
+	golang.org/x/sys v0.20.0 // indirect
+)
@@ -1,31 +1,109 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
+	// Windows has no zone database for time.LoadLocation
+	_ "time/tzdata"
 
 	"github.com/complacentsee/goDatalogConvert/LibDAT"
 	"github.com/complacentsee/goDatalogConvert/LibFTH"
+	"github.com/complacentsee/goDatalogConvert/LibMQTT"
 	"github.com/complacentsee/goDatalogConvert/LibPI"
+	"github.com/complacentsee/goDatalogConvert/LibPIWeb"
 	"github.com/complacentsee/goDatalogConvert/LibUtil"
 )
 
 type datRecord struct {
-	Records     []*LibDAT.DatFloatRecord
-	PointLookup *LibPI.PointLookup
+	FileName      string
+	Records       []*LibDAT.DatFloatRecord
+	StringRecords []*LibDAT.DatStringRecord
+	PointLookup   *LibPI.PointLookup
+	// Seq is the position of the file in the sorted file list, Skip marks files that were not read
+	Seq  int
+	Skip bool
 }
 
 func main() {
 	// Define the command-line flag for the directory path
 	dirPath := flag.String("path", ".", "Path to the directory containing DAT files")
-	host := flag.String("host", "localhost", "hostname of pi server")
+	host := flag.String("host", "localhost", "hostname of pi server, a comma separated list writes every value to each server")
 	processName := flag.String("processName", "dat2fth", "hostname of pi server")
 	tagMapCSV := flag.String("tagMapCSV", "", "Path to the CSV file containing the tag map.")
 	debugLevel := flag.Bool("debug", false, "Enable Debug Logging")
+	batchSize := flag.Int("batchSize", 10000, "Values per pisn_putsnapshotsx call, 0 sends each file in one call")
+	autoBatch := flag.Bool("autoBatch", false, "Tune the batch size from the measured throughput, latency and errors, starting at -batchSize")
+	minBatchSize := flag.Int("minBatchSize", 500, "Smallest batch size -autoBatch picks")
+	maxBatchSize := flag.Int("maxBatchSize", 200000, "Largest batch size -autoBatch picks")
+	autoBatchLatency := flag.Duration("autoBatchLatency", 10*time.Second, "Longest write call -autoBatch accepts before it lowers the batch size")
+	writeMode := flag.String("writeMode", "snapshot", "Historian write mode: snapshot, or insert, replace or insert-no-compression to write the archive directly")
+	digitalStateCSV := flag.String("digitalStateCSV", "", "Path to a CSV file mapping datalog values to digital states: PI tag (or *), value, state")
+	skipExisting := flag.Bool("skipExisting", false, "Skip values at or before the newest value each historian point already holds")
+	replace := flag.Bool("replace", false, "Delete the archive events of each point in the time range of a file before writing it, needs -confirmReplace")
+	replaceDryRun := flag.Bool("replaceDryRun", false, "With -replace, only count the archive events that would be deleted and write nothing")
+	confirmReplace := flag.Bool("confirmReplace", false, "Confirm that -replace may delete archive data")
+	retryAttempts := flag.Int("retryAttempts", 5, "Times a batch that failed with a retryable historian error is sent again")
+	retryBudget := flag.Int("retryBudget", 100, "Batch retries allowed over the whole run")
+	retryBackoff := flag.Duration("retryBackoff", time.Second, "Wait before the first reconnect, doubled on every further attempt")
+	mode := flag.String("mode", "import", "import writes records to the sink, dump writes them to stdout as JSON Lines, verify reconciles the historian archive with them, diagnose checks the historian connection, preflight checks the attributes of the mapped points, createpoints writes a file creating the missing ones, matchtags proposes a tag map")
+	pointListCSV := flag.String("pointListCSV", "", "Exported point list with tag, instrumenttag, exdesc and descriptor columns for matchtags mode, the historian is searched without it")
+	pointMask := flag.String("pointMask", "*", "Names of the historian points matchtags mode searches, with * and ? as wildcards")
+	proposedTagMap := flag.String("proposedTagMap", "proposed_tagmap.csv", "Tag map proposed by matchtags mode, in the format of -tagMapCSV")
+	matchReport := flag.String("matchReport", "match_report.csv", "Confidence and method of every proposed match, written by matchtags mode for review")
+	matchThreshold := flag.Float64("matchThreshold", 0.6, "Lowest name similarity, from 0 to 1, matchtags mode proposes a fuzzy match for")
+	createPointsFormat := flag.String("createPointsFormat", "piconfig", "File written in createpoints mode: piconfig for a piconfig script, builder for a PI Builder CSV")
+	createPointsFile := flag.String("createPointsFile", "", "Path of the createpoints file, create_points.txt or create_points.csv by default")
+	pointTemplateCSV := flag.String("pointTemplateCSV", "", "Path to a CSV file of attributes for new points: pointtype (or *), attribute, value")
+	preflightReport := flag.String("preflightReport", "preflight_report.csv", "Per point attribute report written in preflight mode")
+	piUser := flag.String("piUser", "", "Log in to the historian with piut_login as this user instead of relying on a trust")
+	piPasswordEnv := flag.String("piPasswordEnv", "PI_PASSWORD", "Environment variable holding the password of -piUser, it is asked for when the variable isn't set")
+	diagnosePoint := flag.String("diagnosePoint", "", "Point to check the access to in diagnose mode")
+	diagnoseWrite := flag.Bool("diagnoseWrite", false, "In diagnose mode, write the snapshot value of -diagnosePoint again at the current time to test write access")
+	verifyReport := flag.String("verifyReport", "verify_report.csv", "Per point reconciliation report written in verify mode")
+	verifyTolerance := flag.Float64("verifyTolerance", 0.001, "Largest difference between a source value and the archive accepted in verify mode")
+	verifySamples := flag.Int("verifySamples", 10, "Source values per point and file checked against the archive in verify mode")
+	maxEventsPerSecond := flag.Float64("maxEventsPerSecond", 0, "Highest rate values are written to each historian server at, 0 for no limit")
+	maxInFlight := flag.Int("maxInFlight", 0, "Most worker processes writing to a historian server at once, 0 for no limit")
+	adaptiveLatency := flag.Duration("adaptiveLatency", 0, "Halve the write rate whenever a write call takes longer than this, 0 turns the adaptive mode off")
+	writeWindows := flag.String("writeWindows", "", "Comma separated local times writes are allowed, like 22:00-06:00 or Sat-Sun 00:00-24:00, the import pauses outside them")
+	maxUnresolved := flag.Int("maxUnresolved", -1, "Most mapped points allowed to have no historian point before the import is aborted, -1 for no limit")
+	maxUnresolvedPercent := flag.Float64("maxUnresolvedPercent", 100, "Largest percentage of mapped points allowed to have no historian point before the import is aborted")
+	timeZone := flag.String("timeZone", "", "Time zone of the datalog times for the sinks that write UTC, e.g. Europe/Berlin, the zone of this machine by default")
+	sink := flag.String("sink", "fth", "Output target for records: fth, influx, sqlite, piwebapi or mqtt")
+	var opts sinkOptions
+	flag.StringVar(&opts.failedJournal, "failedJournal", "failed_batches.jsonl", "Journal of values a server didn't take when writing to several servers")
+	replayFailed := flag.String("replayFailed", "", "Journal of an earlier run, only its values for the servers in -host are written")
+	flag.IntVar(&opts.workers, "workers", 0, "Historian worker processes for the fth sink, each with its own connection, 0 writes from this process")
+	flag.StringVar(&opts.influxURL, "influxURL", "", "Base URL of the InfluxDB server, token is read from INFLUX_TOKEN")
+	flag.StringVar(&opts.influxOrg, "influxOrg", "", "InfluxDB organization")
+	flag.StringVar(&opts.influxBucket, "influxBucket", "", "InfluxDB bucket")
+	flag.StringVar(&opts.influxFile, "influxFile", "", "Write line protocol to this file instead of an InfluxDB server")
+	flag.StringVar(&opts.influxMeasurement, "influxMeasurement", "datalog", "Default InfluxDB measurement")
+	flag.StringVar(&opts.influxField, "influxField", "value", "Default InfluxDB field name")
+	flag.StringVar(&opts.influxTagKey, "influxTagKey", "tag", "InfluxDB tag key holding the mapped tag name")
+	flag.IntVar(&opts.influxBatch, "influxBatch", 5000, "Lines per InfluxDB write request")
+	flag.StringVar(&opts.sqliteDB, "sqliteDB", "", "Path of the SQLite database for the sqlite sink")
+	flag.StringVar(&opts.piWebURL, "piWebURL", "", "PI Web API base URL, e.g. https://server/piwebapi")
+	flag.StringVar(&opts.piWebUser, "piWebUser", "", "PI Web API basic auth user, password is read from PIWEBAPI_PASSWORD")
+	flag.IntVar(&opts.piWebBatch, "piWebBatch", 10000, "Values per PI Web API streamsets/recorded request")
+	piWebUpdate := flag.String("piWebUpdateOption", "Insert", "How the piwebapi sink writes values at times that already hold one: Insert, Replace, NoReplace, ReplaceOnly or InsertNoCompression")
+	flag.StringVar(&opts.mqtt.Broker, "mqttBroker", "", "MQTT broker URL, e.g. tcp://broker:1883")
+	flag.StringVar(&opts.mqtt.ClientID, "mqttClientID", "dat2fth", "MQTT client id")
+	flag.StringVar(&opts.mqtt.User, "mqttUser", "", "MQTT user, password is read from MQTT_PASSWORD")
+	mqttQoS := flag.Int("mqttQoS", 1, "MQTT QoS level for published records")
+	mqttFormat := flag.String("mqttFormat", "json", "MQTT payload format: json or spb (Sparkplug B)")
+	flag.StringVar(&opts.mqtt.TopicPrefix, "mqttTopic", "datalog", "Topic prefix for json payloads, the mapped tag name is appended")
+	flag.StringVar(&opts.mqtt.GroupID, "mqttGroup", "datalog", "Sparkplug B group id")
+	flag.StringVar(&opts.mqtt.EdgeNode, "mqttEdgeNode", "dat2fth", "Sparkplug B edge node id")
+	flag.StringVar(&opts.mqtt.Device, "mqttDevice", "replay", "Sparkplug B device id")
+	flag.Float64Var(&opts.mqtt.Speed, "mqttSpeed", 0, "Replay speed factor, 1 replays in real time, 0 publishes as fast as possible")
 	flag.Parse()
 
 	var programLevel = new(slog.LevelVar) // Info by default
@@ -33,7 +111,12 @@ func main() {
 	tagMaps := make(map[string]string)
 	useTagMap := false
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: programLevel}))
+	// Keep stdout clean for the records when dumping and for the batches of a worker process
+	logOutput := os.Stdout
+	if *mode == "dump" || *mode == "worker" {
+		logOutput = os.Stderr
+	}
+	logger := slog.New(slog.NewTextHandler(logOutput, &slog.HandlerOptions{Level: programLevel}))
 	slog.SetDefault(logger)
 
 	var wg sync.WaitGroup
@@ -62,15 +145,168 @@ func main() {
 		slog.Info("No tag map provided. Continuing without loading tag map.")
 	}
 
-	slog.Info(fmt.Sprintf("Connecting to piserver at: %s, with process name %s", *host, *processName))
+	opts.zone = time.Local
+	if *timeZone != "" {
+		zone, err := time.LoadLocation(*timeZone)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Invalid -timeZone: %v", err))
+			return
+		}
+		opts.zone = zone
+	}
+
+	switch *mode {
+	case "import", "worker", "verify", "diagnose", "preflight", "createpoints", "matchtags":
+	case "dump":
+		if err := runDump(os.Stdout, *dirPath, tagMaps, opts.zone); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	default:
+		slog.Error(fmt.Sprintf("Unknown mode %q", *mode))
+		return
+	}
+
+	LibFTH.SetBatchSize(*batchSize)
+	if *autoBatch {
+		LibFTH.SetAutoBatch(LibFTH.AutoBatch{Min: max(*minBatchSize, 1), Max: max(*maxBatchSize, *minBatchSize, 1), Latency: *autoBatchLatency})
+	}
+	historianMode, err := LibFTH.ParseWriteMode(*writeMode)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	LibFTH.SetWriteMode(historianMode)
+	LibFTH.SetSkipExisting(*skipExisting)
+	if *replace && !*replaceDryRun && !*confirmReplace {
+		slog.Error("-replace deletes archive data, run it with -replaceDryRun to see how much, then add -confirmReplace")
+		return
+	}
+	LibFTH.SetReplace(*replace, *replaceDryRun)
+	windows, err := LibFTH.ParseWriteWindows(*writeWindows)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	LibFTH.SetThrottle(LibFTH.Throttle{EventsPerSecond: *maxEventsPerSecond, InFlight: *maxInFlight, LatencyThreshold: *adaptiveLatency, Windows: windows})
+	LibFTH.SetRetryPolicy(LibFTH.RetryPolicy{Attempts: *retryAttempts, Budget: *retryBudget, Backoff: *retryBackoff})
+	if *digitalStateCSV != "" {
+		states := LibFTH.DigitalStates{}
+		if err := LibFTH.LoadDigitalStatesCSV(*digitalStateCSV, states); err != nil {
+			slog.Error(fmt.Sprintf("Failed to load digital state map: %v", err))
+			return
+		}
+		LibFTH.SetDigitalStates(states)
+	}
+
+	if *piUser != "" {
+		// A worker's stdin carries the batches, it gets the password through the environment
+		password, err := readPassword(*piPasswordEnv, *piUser, *mode != "worker")
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		LibFTH.SetLogin(*piUser, password)
+	}
+
+	if *mode == "diagnose" {
+		if err := runDiagnose(*host, *processName, *diagnosePoint, *diagnoseWrite); err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *mode == "worker" {
+		if err := runWorker(os.Stdin, os.Stdout, *host, *processName); err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	opts.hosts = strings.Split(*host, ",")
+	for i := range opts.hosts {
+		opts.hosts[i] = strings.TrimSpace(opts.hosts[i])
+	}
+	opts.host = opts.hosts[0]
+	if *replayFailed != "" {
+		// The replayed journal is rewritten with its replayed marks, new failures go to another file
+		replayPath, _ := filepath.Abs(*replayFailed)
+		journalPath, _ := filepath.Abs(opts.failedJournal)
+		if replayPath == journalPath {
+			slog.Error("-failedJournal must name another file than -replayFailed")
+			return
+		}
+		opts.replay, err = loadFailedBatches(*replayFailed)
+		if err != nil {
+			slog.Error(err.Error())
+			return
+		}
+	}
+	opts.processName = *processName
+	opts.tagMapCSV = *tagMapCSV
+	opts.dirPath = *dirPath
+	opts.mqtt.QoS = byte(*mqttQoS)
+	mqttPayload, err := LibMQTT.ParseFormat(*mqttFormat)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	opts.mqtt.Format = mqttPayload
+	opts.piWebUpdate, err = LibPIWeb.ParseUpdateOption(*piWebUpdate)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	if *mode == "preflight" || *mode == "createpoints" || *mode == "matchtags" {
+		dr, err := LibDAT.NewDatReader(*dirPath)
+		if err == nil && *mode == "preflight" {
+			err = runPreflight(dr, tagMaps, useTagMap, opts, *preflightReport)
+		} else if err == nil && *mode == "matchtags" {
+			err = runMatchTags(dr, opts, *pointListCSV, *pointMask, *proposedTagMap, *matchReport, *matchThreshold)
+		} else if err == nil {
+			outPath := *createPointsFile
+			if outPath == "" {
+				outPath = "create_points.txt"
+				if *createPointsFormat == "builder" {
+					outPath = "create_points.csv"
+				}
+			}
+			err = runCreatePoints(dr, tagMaps, useTagMap, opts, *createPointsFormat, outPath, *pointTemplateCSV)
+		}
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Resolve every point up front, so a bad tag map stops the run before anything is written
+	if *sink == "fth" || *mode == "verify" {
+		dr, err := LibDAT.NewDatReader(*dirPath)
+		if err == nil {
+			err = resolveAll(dr, tagMaps, useTagMap, opts, unresolvedLimit{count: *maxUnresolved, percent: *maxUnresolvedPercent})
+		}
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+	}
 
-	LibFTH.SetProcessName(*processName)
-	err := LibFTH.Connect(*host)
+	var writer recordWriter
+	if *mode == "verify" {
+		writer, err = newVerifyWriter(*verifyReport, *verifyTolerance, *verifySamples, opts)
+	} else {
+		writer, err = newRecordWriter(*sink, opts)
+	}
 	if err != nil {
 		slog.Error(err.Error())
+		logHint(err, opts.processName)
 		return
 	}
-	defer LibFTH.Disconnect()
+	defer writer.Close()
 
 	if _, err := os.Stat(*dirPath); os.IsNotExist(err) {
 		slog.Error("Error: Directory not found")
@@ -90,15 +326,36 @@ func main() {
 	recordChan := make(chan datRecord)
 	doneChan := make(chan struct{})
 
+	// Ordered writers keep the files read ahead of the one being written in memory, a file only
+	// starts reading once a slot is free, so no more files than can be read at once are held
+	var window chan struct{}
+	if _, ok := writer.(orderedWriter); ok {
+		window = make(chan struct{}, cap(sem))
+	}
+
 	// Start inserter goroutine
-	go insertRecords(recordChan, doneChan)
+	go insertRecords(writer, recordChan, window, doneChan)
 
 	// Start processing files one by one in a separate goroutine
 	go func() {
-		for _, floatfileName := range dr.GetFloatFiles() {
+		files := dr.GetFloatFiles()
+		if fs, ok := writer.(fileSelector); ok {
+			var selected []string
+			for _, fileName := range files {
+				if fs.Selects(fileName) {
+					selected = append(selected, fileName)
+				}
+			}
+			slog.Info(fmt.Sprintf("Writing %d of %d files", len(selected), len(files)))
+			files = selected
+		}
+		for seq, floatfileName := range files {
+			if window != nil {
+				window <- struct{}{} // Released once the file is written
+			}
 			wg.Add(1)         // Increment WaitGroup counter for each file to process
 			sem <- struct{}{} // Acquire semaphore slot
-			go processFile(floatfileName, dr, tagMaps, useTagMap, recordChan, &wg, sem)
+			go processFile(seq, floatfileName, dr, writer, tagMaps, useTagMap, recordChan, &wg, sem)
 		}
 
 		// Wait for all processing to finish and close the channel
@@ -109,13 +366,37 @@ func main() {
 	// Wait for all records to be inserted
 	<-doneChan
 
+	if rr, ok := writer.(runReporter); ok {
+		rr.Report()
+	}
+
 	slog.Info("Processing complete.")
 }
 
-func processFile(fileName string, dr *LibDAT.DatReader, tagMaps map[string]string, useTagMap bool, recordChan chan<- datRecord, wg *sync.WaitGroup, sem chan struct{}) {
+func processFile(seq int, fileName string, dr *LibDAT.DatReader, writer recordWriter, tagMaps map[string]string, useTagMap bool, recordChan chan<- datRecord, wg *sync.WaitGroup, sem chan struct{}) {
 	defer wg.Done()          // Decrement the counter when the function returns
 	defer func() { <-sem }() // Release semaphore slot when done
 
+	// Files that are not read still take their turn, so ordered writers don't wait for them
+	sent := false
+	defer func() {
+		if !sent {
+			recordChan <- datRecord{FileName: fileName, Seq: seq, Skip: true}
+		}
+	}()
+
+	if iw, ok := writer.(incrementalWriter); ok {
+		imported, err := iw.Imported(fileName)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error checking import state for %s: %v", fileName, err))
+			return
+		}
+		if imported {
+			slog.Info(fmt.Sprintf("Skipping %s, already imported", fileName))
+			return
+		}
+	}
+
 	start := time.Now()
 	pointCache := LibPI.NewPointLookup()
 
@@ -129,7 +410,7 @@ func processFile(fileName string, dr *LibDAT.DatReader, tagMaps map[string]strin
 		tagName := tag.Name
 		if useTagMap {
 			var exists bool
-			tagName, exists = tagMaps[tag.Name]
+			tagName, exists = tagMaps[strings.ToUpper(tag.Name)]
 			if !exists {
 				continue
 			}
@@ -141,7 +422,7 @@ func processFile(fileName string, dr *LibDAT.DatReader, tagMaps map[string]strin
 			continue
 		}
 
-		pointC := LibFTH.AddToPIPointCache(tag.Name, tag.ID, 0, tagName)
+		pointC := writer.ResolvePoint(tag, tagName)
 		pointCache.AddPoint(pointC)
 	}
 	pointCache.PrintAll()
@@ -152,8 +433,18 @@ func processFile(fileName string, dr *LibDAT.DatReader, tagMaps map[string]strin
 		return
 	}
 
+	var stringRecords []*LibDAT.DatStringRecord
+	if _, ok := writer.(stringRecordWriter); ok {
+		stringRecords, err = dr.ReadStringFile(fileName)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error(fmt.Sprintf("Error reading string file for %s: %v", fileName, err))
+			return
+		}
+	}
+
 	// Immediately send the records to the channel for historian processing
-	recordChan <- datRecord{Records: records, PointLookup: pointCache}
+	recordChan <- datRecord{FileName: fileName, Records: records, StringRecords: stringRecords, PointLookup: pointCache, Seq: seq}
+	sent = true
 
 	duration := time.Since(start)
 	slog.Info(fmt.Sprintf("Loaded %d records from %s in %f seconds", len(records), fileName, duration.Seconds()))
@@ -162,21 +453,26 @@ func processFile(fileName string, dr *LibDAT.DatReader, tagMaps map[string]strin
 	// (This was moved from the defer to here to ensure it happens as soon as possible)
 }
 
-func insertRecords(recordChan <-chan datRecord, doneChan chan<- struct{}) {
+func insertRecords(writer recordWriter, recordChan <-chan datRecord, window <-chan struct{}, doneChan chan<- struct{}) {
+	if _, ok := writer.(orderedWriter); ok {
+		insertRecordsInOrder(writer, recordChan, window)
+		doneChan <- struct{}{}
+		return
+	}
+
 	var wg sync.WaitGroup // Use a separate WaitGroup for the historian inserts
 
 	for datrecords := range recordChan {
+		if datrecords.Skip {
+			continue
+		}
 		wg.Add(1)
 		go func(dr datRecord) {
 			defer wg.Done()
 
-			records := dr.Records
-			pointCache := dr.PointLookup
-			err := LibFTH.ConvertDatFloatRecordsToPutSnapshots(records, pointCache)
-			if err != nil {
-				slog.Error(fmt.Sprintf("Error inserting values into historian: %v", err))
+			if err := writer.WriteFile(dr); err != nil {
+				slog.Error(fmt.Sprintf("Error writing values from %s: %v", dr.FileName, err))
 			}
-
 		}(datrecords)
 	}
 
@@ -186,3 +482,29 @@ func insertRecords(recordChan <-chan datRecord, doneChan chan<- struct{}) {
 	// Signal completion
 	doneChan <- struct{}{}
 }
+
+// insertRecordsInOrder writes the files one at a time in the order of the file list, so the
+// values of each point reach the writer in time order however the files were read. Each file
+// written or skipped frees its slot in window for the next file to be read.
+func insertRecordsInOrder(writer recordWriter, recordChan <-chan datRecord, window <-chan struct{}) {
+	pending := make(map[int]datRecord)
+	next := 0
+
+	for datrecords := range recordChan {
+		pending[datrecords.Seq] = datrecords
+		for {
+			dr, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if !dr.Skip {
+				if err := writer.WriteFile(dr); err != nil {
+					slog.Error(fmt.Sprintf("Error writing values from %s: %v", dr.FileName, err))
+				}
+			}
+			<-window
+		}
+	}
+}
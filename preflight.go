@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibFTH"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// datalogPoint is a historian point with what the datalogs hold for it over all files
+type datalogPoint struct {
+	PIName       string
+	DatalogNames []string
+	TagType      int
+	Count        int
+	// NotFinite counts the NaN and infinite values, which are not in Count, Min and Max
+	NotFinite int
+	Min       float64
+	Max       float64
+}
+
+// scanDatalogs reads the tags of every file and maps them to historian points like an import would.
+// With values set the float files are read too, for the count and range of each point.
+func scanDatalogs(dr *LibDAT.DatReader, tagMaps map[string]string, useTagMap bool, values bool) ([]*datalogPoint, error) {
+	points := make(map[string]*datalogPoint)
+	var order []*datalogPoint
+	for _, fileName := range dr.GetFloatFiles() {
+		tags, err := dr.ReadTagFile(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("error reading tag file for %s: %w", fileName, err)
+		}
+		byID := make(map[int]*datalogPoint, len(tags))
+		for _, tag := range tags {
+			tagName := tag.Name
+			if useTagMap {
+				var exists bool
+				if tagName, exists = tagMaps[strings.ToUpper(tag.Name)]; !exists {
+					continue
+				}
+			}
+			key := strings.ToUpper(tagName)
+			point, ok := points[key]
+			if !ok {
+				point = &datalogPoint{PIName: tagName, TagType: tag.Type, Min: math.Inf(1), Max: math.Inf(-1)}
+				points[key] = point
+				order = append(order, point)
+			}
+			if !containsFold(point.DatalogNames, tag.Name) {
+				point.DatalogNames = append(point.DatalogNames, tag.Name)
+			}
+			byID[tag.ID] = point
+		}
+		if !values {
+			continue
+		}
+
+		records, err := dr.ReadFloatFile(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("error reading float file for %s: %w", fileName, err)
+		}
+		for _, record := range records {
+			if record == nil {
+				continue
+			}
+			if point, ok := byID[record.TagID]; ok {
+				point.add(record.Val)
+			}
+		}
+	}
+	return order, nil
+}
+
+// add counts a datalog value of the point. NaN would stick to Min and Max, so it is counted apart like infinities.
+func (p *datalogPoint) add(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		p.NotFinite++
+		return
+	}
+	p.Count++
+	p.Min = math.Min(p.Min, v)
+	p.Max = math.Max(p.Max, v)
+}
+
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// runPreflight reads the attributes of every mapped point and warns about settings that don't suit
+// the datalog values, before anything is written. The findings are also written to a CSV report.
+func runPreflight(dr *LibDAT.DatReader, tagMaps map[string]string, useTagMap bool, opts sinkOptions, reportPath string) error {
+	points, err := scanDatalogs(dr, tagMaps, useTagMap, true)
+	if err != nil {
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("Connecting to piserver at: %s, with process name %s", opts.host, opts.processName))
+	LibFTH.SetProcessName(opts.processName)
+	if err := LibFTH.Connect(opts.host); err != nil {
+		logHint(err, opts.processName)
+		return err
+	}
+	defer LibFTH.Disconnect()
+
+	file, err := os.Create(reportPath)
+	if err != nil {
+		return fmt.Errorf("failed to create preflight report: %w", err)
+	}
+	defer file.Close()
+	report := csv.NewWriter(file)
+	report.Write([]string{"point", "datalog_tags", "datalog_type", "values", "not_finite", "min", "max", "pointtype", "compressing",
+		"compdev", "excdev", "zero", "span", "engunits", "step", "warnings"})
+
+	sort.Slice(points, func(i, j int) bool { return points[i].PIName < points[j].PIName })
+	warned, missing := 0, 0
+	for _, point := range points {
+		row := []string{point.PIName, strings.Join(point.DatalogNames, ";"), strconv.Itoa(point.TagType), strconv.Itoa(point.Count),
+			strconv.Itoa(point.NotFinite), "", "", "", "", "", "", "", "", "", "", ""}
+		if point.Count > 0 {
+			row[5], row[6] = formatFloat(point.Min), formatFloat(point.Max)
+		}
+
+		var warnings []string
+		ptNumber, err := LibFTH.GetPointNumber(point.PIName)
+		if err == nil {
+			var attrs LibFTH.PointAttributes
+			attrs, err = LibFTH.GetPointAttributes(ptNumber)
+			if err == nil {
+				row[7], row[8], row[9], row[10] = attrs.Type.String(), strconv.FormatBool(attrs.Compressing), formatFloat(attrs.CompDev), formatFloat(attrs.ExcDev)
+				row[11], row[12], row[13], row[14] = formatFloat(attrs.Zero), formatFloat(attrs.Span), attrs.EngUnits, strconv.FormatBool(attrs.Step)
+				warnings = pointWarnings(point, attrs)
+			}
+		}
+		if err != nil {
+			missing++
+			warnings = []string{err.Error()}
+		}
+
+		if len(warnings) > 0 {
+			warned++
+			for _, w := range warnings {
+				slog.Warn(fmt.Sprintf("%s: %s", point.PIName, w))
+			}
+		}
+		row[15] = strings.Join(warnings, "; ")
+		report.Write(row)
+	}
+	report.Flush()
+	if err := report.Error(); err != nil {
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("Checked %d points, %d with warnings, %d could not be read", len(points), warned, missing))
+	slog.Info(fmt.Sprintf("Preflight report written to %s", reportPath))
+	return nil
+}
+
+// pointWarnings lists the attributes of a point that don't suit the values the datalogs hold for it
+func pointWarnings(point *datalogPoint, attrs LibFTH.PointAttributes) []string {
+	var warnings []string
+	if attrs.Type == LibPI.PointTypeUnknown {
+		warnings = append(warnings, "the point is not a real, integer or digital point, its values will not be written")
+	}
+	if !attrs.Compressing {
+		warnings = append(warnings, "compression is off, every value will be archived")
+	} else if attrs.CompDev == 0 {
+		warnings = append(warnings, "CompDev is 0, compression keeps nearly every value")
+	}
+
+	if point.NotFinite > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d datalog values are NaN or infinite, they are left out of the range", point.NotFinite))
+	}
+
+	// Zero, span and CompDev mean nothing for digital states
+	digital := attrs.Type == LibPI.PointTypeDigital || point.TagType == LibDAT.TagTypeDigital
+	if point.Count > 0 && !digital {
+		if point.Min < attrs.Zero || point.Max > attrs.Zero+attrs.Span {
+			warnings = append(warnings, fmt.Sprintf("zero %s and span %s are too narrow for the datalog values from %s to %s",
+				formatFloat(attrs.Zero), formatFloat(attrs.Span), formatFloat(point.Min), formatFloat(point.Max)))
+		}
+		if attrs.Compressing && point.Max > point.Min && attrs.CompDev > (point.Max-point.Min)/10 {
+			warnings = append(warnings, fmt.Sprintf("CompDev %s is more than a tenth of the datalog range, smaller changes will be dropped",
+				formatFloat(attrs.CompDev)))
+		}
+	}
+
+	if point.Count == 0 {
+		return warnings
+	}
+	switch {
+	case point.TagType == LibDAT.TagTypeDigital && attrs.Type == LibPI.PointTypeReal && !attrs.Step:
+		warnings = append(warnings, "the datalog tag is digital but the point is not step, the archive will interpolate between states")
+	case point.TagType == LibDAT.TagTypeAnalog && attrs.Step:
+		warnings = append(warnings, "the datalog tag is analog but the point is step, the archive will not interpolate between values")
+	}
+	if point.TagType == LibDAT.TagTypeAnalog && attrs.Type != LibPI.PointTypeReal && attrs.Type != LibPI.PointTypeUnknown {
+		warnings = append(warnings, fmt.Sprintf("the datalog tag is analog but the point is %s, fractions will be lost", attrs.Type))
+	}
+	return warnings
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// unresolvedLimit aborts a run when too many mapped points have no historian point
+type unresolvedLimit struct {
+	// count is the most unresolved points allowed, -1 for no limit
+	count int
+	// percent is the largest share of unresolved points allowed
+	percent float64
+}
+
+// exceeded reports whether unresolved of total points go over the limit
+func (l unresolvedLimit) exceeded(unresolved int, total int) bool {
+	if l.count >= 0 && unresolved > l.count {
+		return true
+	}
+	return total > 0 && float64(unresolved)*100/float64(total) > l.percent
+}
+
+// resolveAll looks up the points of every (Tagname) file on each server before anything is written,
+// lists the points that don't resolve and fails when there are more than the limit allows. The
+// lookups stay cached, so the import doesn't ask for them again.
+func resolveAll(dr *LibDAT.DatReader, tagMaps map[string]string, useTagMap bool, opts sinkOptions, limit unresolvedLimit) error {
+	points, err := scanDatalogs(dr, tagMaps, useTagMap, false)
+	if err != nil {
+		return err
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].PIName < points[j].PIName })
+
+	LibFTH.SetProcessName(opts.processName)
+	for _, host := range opts.hosts {
+		slog.Info(fmt.Sprintf("Resolving %d points on %s before writing", len(points), host))
+		if err := LibFTH.Connect(host); err != nil {
+			logHint(err, opts.processName)
+			return err
+		}
+
+		unresolved := 0
+		for _, point := range points {
+			_, err := LibFTH.GetPointNumber(point.PIName)
+			if err == nil {
+				continue
+			}
+			var piErr *LibFTH.PIError
+			if errors.As(err, &piErr) && !errors.Is(err, LibFTH.ErrPointLevel) {
+				LibFTH.Disconnect()
+				return fmt.Errorf("resolving points on %s failed: %w", host, err)
+			}
+			unresolved++
+			slog.Warn(fmt.Sprintf("Unresolved point %s, datalog tags %s: %v", point.PIName, strings.Join(point.DatalogNames, ", "), err))
+		}
+
+		if len(opts.hosts) > 1 || opts.workers > 0 {
+			// The worker processes connect on their own
+			LibFTH.Disconnect()
+		}
+		if unresolved == 0 {
+			slog.Info(fmt.Sprintf("All %d points resolved on %s", len(points), host))
+			continue
+		}
+		msg := fmt.Sprintf("%d of %d points did not resolve on %s", unresolved, len(points), host)
+		if limit.exceeded(unresolved, len(points)) {
+			return fmt.Errorf("%s, over the limit of -maxUnresolved %d and -maxUnresolvedPercent %g, nothing was written",
+				msg, limit.count, limit.percent)
+		}
+		slog.Warn(msg + ", their values will not be written")
+	}
+	return nil
+}
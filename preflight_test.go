@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibFTH"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+func TestDatalogPointAdd(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    []float64
+		count     int
+		notFinite int
+		min, max  float64
+	}{
+		{"finite", []float64{3, -1, 2}, 3, 0, -1, 3},
+		{"NaN first", []float64{math.NaN(), 3, -1}, 2, 1, -1, 3},
+		{"NaN between", []float64{3, math.NaN(), -1}, 2, 1, -1, 3},
+		{"infinities", []float64{math.Inf(1), 5, math.Inf(-1)}, 1, 2, 5, 5},
+		{"only NaN", []float64{math.NaN(), math.NaN()}, 0, 2, math.Inf(1), math.Inf(-1)},
+	}
+	for _, tt := range tests {
+		point := &datalogPoint{Min: math.Inf(1), Max: math.Inf(-1)}
+		for _, v := range tt.values {
+			point.add(v)
+		}
+		if point.Count != tt.count || point.NotFinite != tt.notFinite || point.Min != tt.min || point.Max != tt.max {
+			t.Errorf("%s: count %d, not finite %d, range %v to %v, want %d, %d, %v to %v", tt.name,
+				point.Count, point.NotFinite, point.Min, point.Max, tt.count, tt.notFinite, tt.min, tt.max)
+		}
+	}
+}
+
+func TestPointWarningsNotFinite(t *testing.T) {
+	point := &datalogPoint{PIName: "TIC101", TagType: LibDAT.TagTypeAnalog, Min: math.Inf(1), Max: math.Inf(-1)}
+	for _, v := range []float64{10, math.NaN(), 20} {
+		point.add(v)
+	}
+	attrs := LibFTH.PointAttributes{Type: LibPI.PointTypeReal, Compressing: true, CompDev: 0.5, Zero: 0, Span: 100}
+	warnings := pointWarnings(point, attrs)
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "1 datalog values are NaN or infinite") {
+		t.Errorf("warnings %q, want only the one about the NaN value", warnings)
+	}
+}
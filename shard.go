@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibFTH"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// shardPoint is a point of a shard batch, the worker resolves it on its own connection
+type shardPoint struct {
+	TagID       int
+	DatalogName string
+	PIName      string
+}
+
+// shardRecord is a float value of a shard batch
+type shardRecord struct {
+	TagID int
+	Time  time.Time
+	Val   float64
+}
+
+// shardBatch carries the values of one file for the points of one shard to a worker process
+type shardBatch struct {
+	File    string
+	Points  []shardPoint
+	Records []shardRecord
+}
+
+// shardResult is the answer of a worker process to one shardBatch
+type shardResult struct {
+	File   string
+	Result *LibFTH.WriteResult
+	Err    string
+}
+
+// shardWorker is a child process with its own historian connection
+type shardWorker struct {
+	id      int
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	enc     *gob.Encoder
+	dec     *gob.Decoder
+	results int
+	// dead is set when the worker failed to take a batch or to answer, it gets no more batches
+	dead bool
+}
+
+// shardedWriter is the coordinator for -workers. Every point belongs to one worker process, picked
+// by a hash of its historian name, and files are handed over in order, so each point stays in time order.
+// The points of a worker that stops answering move to the remaining workers for the following files.
+type shardedWriter struct {
+	// name prefixes the log lines when several servers are written, it is empty otherwise
+	name    string
+	workers []*shardWorker
+	mu      sync.Mutex
+	run     *LibFTH.WriteResult
+}
+
+// newShardedWriter starts n copies of this program in worker mode, connected to host. They get the
+// same flags, so settings like -batchSize and -writeMode apply to them too, and a process name of their own.
+func newShardedWriter(n int, host string, processName string, name string) (*shardedWriter, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the executable for the worker processes: %w", err)
+	}
+
+	w := &shardedWriter{name: name, run: LibFTH.NewRunResult()}
+	for i := 0; i < n; i++ {
+		// The workers share the write rate of the server, the coordinator keeps the in-flight limit
+		args := append(append([]string(nil), os.Args[1:]...),
+			"-mode", "worker", "-workers", "0", "-host", host, "-processName", fmt.Sprintf("%s%d", processName, i),
+			"-maxEventsPerSecond", strconv.FormatFloat(LibFTH.GetThrottle().EventsPerSecond/float64(n), 'g', -1, 64), "-maxInFlight", "0")
+		cmd := exec.Command(exe, args...)
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			w.Close()
+			return nil, fmt.Errorf("failed to start worker %d: %w", i, err)
+		}
+		w.workers = append(w.workers, &shardWorker{id: i, cmd: cmd, stdin: stdin, enc: gob.NewEncoder(stdin), dec: gob.NewDecoder(stdout)})
+	}
+	slog.Info(fmt.Sprintf("Started %d historian worker processes for %s", n, host))
+	return w, nil
+}
+
+func (*shardedWriter) writesInOrder() {}
+
+// shardOf picks the worker of a point, the same name always lands on the same worker while it is
+// alive. The points of dead workers are spread over the live ones. It returns -1 when none is left.
+func (w *shardedWriter) shardOf(piName string) int {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToUpper(piName)))
+	sum := h.Sum32()
+	if shard := int(sum % uint32(len(w.workers))); !w.workers[shard].dead {
+		return shard
+	}
+	var live []int
+	for i, worker := range w.workers {
+		if !worker.dead {
+			live = append(live, i)
+		}
+	}
+	if len(live) == 0 {
+		return -1
+	}
+	return live[sum%uint32(len(live))]
+}
+
+// markDead stops sending batches to a worker that failed. Its process is killed, so it can't write
+// values of its points later than the worker they move to.
+func (w *shardedWriter) markDead(worker *shardWorker, err error) {
+	worker.dead = true
+	if worker.cmd != nil && worker.cmd.Process != nil {
+		worker.cmd.Process.Kill()
+	}
+	slog.Error(fmt.Sprintf("%s: worker %d failed, its points go to the other workers: %v", w.scope("workers"), worker.id, err))
+}
+
+// ResolvePoint leaves the lookup to the worker that owns the point
+func (*shardedWriter) ResolvePoint(tag *LibDAT.DatTagRecord, targetName string) *LibPI.PointCache {
+	return namedPoint(tag, targetName)
+}
+
+// scope names a file in the log lines of this writer
+func (w *shardedWriter) scope(fileName string) string {
+	if w.name == "" {
+		return filepath.Base(fileName)
+	}
+	return w.name + ": " + filepath.Base(fileName)
+}
+
+// WriteFile splits the values of a file by worker, sends each worker its share and waits for all of them
+func (w *shardedWriter) WriteFile(file datRecord) error {
+	_, _, err := w.writeFile(file)
+	return err
+}
+
+// writeFile returns the combined result of the workers and the batches of workers that didn't answer
+func (w *shardedWriter) writeFile(file datRecord) (*LibFTH.WriteResult, []*shardBatch, error) {
+	base := filepath.Base(file.FileName)
+	batches := make([]*shardBatch, len(w.workers))
+	shards := make(map[int]int)
+
+	for _, record := range file.Records {
+		if record == nil {
+			continue
+		}
+		point, exists := file.PointLookup.GetPointByDataLogID(record.TagID)
+		if !exists || !point.Process {
+			continue
+		}
+		shard, ok := shards[record.TagID]
+		if !ok {
+			shard = w.shardOf(point.PIName)
+			if shard < 0 {
+				return LibFTH.NewWriteResult(), nil, fmt.Errorf("all %d worker processes failed", len(w.workers))
+			}
+			shards[record.TagID] = shard
+			if batches[shard] == nil {
+				batches[shard] = &shardBatch{File: base}
+			}
+			batches[shard].Points = append(batches[shard].Points, shardPoint{TagID: point.DataLogID, DatalogName: point.DatalogName, PIName: point.PIName})
+		}
+		batches[shard].Records = append(batches[shard].Records, shardRecord{TagID: record.TagID, Time: record.TimeStamp, Val: record.Val})
+	}
+
+	var pending []int
+	for i, batch := range batches {
+		if batch != nil {
+			pending = append(pending, i)
+		}
+	}
+	// With an in-flight limit only that many workers write at once
+	step := len(pending)
+	if limit := LibFTH.GetThrottle().InFlight; limit > 0 {
+		step = limit
+	}
+
+	var errs []error
+	var lost []*shardBatch
+	result := LibFTH.NewWriteResult()
+	for start := 0; start < len(pending); start += step {
+		var sent []*shardWorker
+		for _, i := range pending[start:min(start+step, len(pending))] {
+			if err := w.workers[i].enc.Encode(batches[i]); err != nil {
+				err = fmt.Errorf("worker %d: %w", i, err)
+				w.markDead(w.workers[i], err)
+				errs = append(errs, err)
+				lost = append(lost, batches[i])
+				continue
+			}
+			sent = append(sent, w.workers[i])
+		}
+
+		for _, worker := range sent {
+			var res shardResult
+			if err := worker.dec.Decode(&res); err != nil {
+				err = fmt.Errorf("worker %d stopped answering: %w", worker.id, err)
+				w.markDead(worker, err)
+				errs = append(errs, err)
+				lost = append(lost, batches[worker.id])
+				continue
+			}
+			worker.results++
+			if res.Result != nil {
+				result.Merge(res.Result)
+			}
+			if res.Err != "" {
+				errs = append(errs, fmt.Errorf("worker %d: %s", worker.id, res.Err))
+			}
+		}
+	}
+
+	result.Log(w.scope(file.FileName))
+	w.mu.Lock()
+	w.run.Merge(result)
+	w.mu.Unlock()
+	return result, lost, errors.Join(errs...)
+}
+
+// Report logs what was and wasn't written over the whole run and how the work was spread
+func (w *shardedWriter) Report() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.run.Log(w.scope("run total"))
+	for _, worker := range w.workers {
+		if worker.dead {
+			slog.Info(fmt.Sprintf("%s: worker %d wrote %d file shards before it failed", w.scope("run total"), worker.id, worker.results))
+			continue
+		}
+		slog.Info(fmt.Sprintf("%s: worker %d wrote %d file shards", w.scope("run total"), worker.id, worker.results))
+	}
+}
+
+// Close ends the input of every worker and waits for them to disconnect and exit
+func (w *shardedWriter) Close() error {
+	var errs []error
+	for _, worker := range w.workers {
+		worker.stdin.Close()
+	}
+	for _, worker := range w.workers {
+		// Dead workers were killed and already reported
+		if err := worker.cmd.Wait(); err != nil && !worker.dead {
+			errs = append(errs, fmt.Errorf("worker %d: %w", worker.id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runWorker serves shard batches from in until it is closed, writing them over this process's
+// own historian connection and answering each with its result on out
+func runWorker(in io.Reader, out io.Writer, host string, processName string) error {
+	LibFTH.SetProcessName(processName)
+	if err := LibFTH.Connect(host); err != nil {
+		return err
+	}
+	defer LibFTH.Disconnect()
+
+	dec := gob.NewDecoder(in)
+	enc := gob.NewEncoder(out)
+	for {
+		var batch shardBatch
+		if err := dec.Decode(&batch); err != nil {
+			if err == io.EOF {
+				LibFTH.LogBatchTuning()
+				return nil
+			}
+			return fmt.Errorf("failed to read batch: %w", err)
+		}
+
+		pointLookup := LibPI.NewPointLookup()
+		for _, p := range batch.Points {
+			pointLookup.AddPoint(LibFTH.AddToPIPointCache(p.DatalogName, p.TagID, 0, p.PIName))
+		}
+		records := make([]*LibDAT.DatFloatRecord, len(batch.Records))
+		for i, r := range batch.Records {
+			records[i] = &LibDAT.DatFloatRecord{TimeStamp: r.Time, TagID: r.TagID, Val: r.Val}
+		}
+
+		res := shardResult{File: batch.File}
+		result, err := LibFTH.ConvertDatFloatRecordsToPutSnapshots(records, pointLookup)
+		res.Result = result
+		if err != nil {
+			res.Err = err.Error()
+		}
+		if err := enc.Encode(&res); err != nil {
+			return fmt.Errorf("failed to send result: %w", err)
+		}
+	}
+}
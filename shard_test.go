@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibFTH"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// pipeWorker answers every batch in place of a worker process, as written in full
+func pipeWorker(t *testing.T, id int) *shardWorker {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	go func() {
+		dec, enc := gob.NewDecoder(inR), gob.NewEncoder(outW)
+		for {
+			var batch shardBatch
+			if err := dec.Decode(&batch); err != nil {
+				outW.Close()
+				return
+			}
+			result := LibFTH.NewWriteResult()
+			result.Batches, result.Sent, result.Succeeded = 1, len(batch.Records), len(batch.Records)
+			enc.Encode(&shardResult{File: batch.File, Result: result})
+		}
+	}()
+	t.Cleanup(func() { inW.Close() })
+	return &shardWorker{id: id, stdin: inW, enc: gob.NewEncoder(inW), dec: gob.NewDecoder(outR)}
+}
+
+// brokenWorker can't take any batch, like a worker process that exited
+func brokenWorker(id int) *shardWorker {
+	inR, inW := io.Pipe()
+	inR.Close()
+	outR, _ := io.Pipe()
+	return &shardWorker{id: id, stdin: inW, enc: gob.NewEncoder(inW), dec: gob.NewDecoder(outR)}
+}
+
+func TestShardRerouteDeadWorker(t *testing.T) {
+	w := &shardedWriter{workers: []*shardWorker{pipeWorker(t, 0), brokenWorker(1), pipeWorker(t, 2)}, run: LibFTH.NewRunResult()}
+	lookup := LibPI.NewPointLookup()
+	var records []*LibDAT.DatFloatRecord
+	owners := make(map[string]int)
+	for id := 0; id < 30; id++ {
+		name := fmt.Sprintf("TIC%d", id)
+		lookup.AddPoint(&LibPI.PointCache{DataLogID: id, PIName: name, Process: true})
+		records = append(records, &LibDAT.DatFloatRecord{TimeStamp: time.Date(2024, time.January, 2, 10, 0, 0, 0, time.UTC), TagID: id, Val: 1})
+		owners[name] = w.shardOf(name)
+	}
+	file := datRecord{FileName: "2024 01 02 0000 (Float).DAT", Records: records, PointLookup: lookup}
+
+	result, lost, err := w.writeFile(file)
+	if err == nil || !w.workers[1].dead || w.workers[0].dead || w.workers[2].dead {
+		t.Fatalf("the broken worker was not the only one marked dead, error %v", err)
+	}
+	if len(lost) != 1 || result.Sent+len(lost[0].Records) != len(records) {
+		t.Fatalf("%d values written and %d batches lost, want the share of worker 1 lost", result.Sent, len(lost))
+	}
+	for _, p := range lost[0].Points {
+		if owners[p.PIName] != 1 {
+			t.Errorf("lost point %s belonged to worker %d", p.PIName, owners[p.PIName])
+		}
+	}
+
+	// Only the points of the dead worker move
+	for name, owner := range owners {
+		shard := w.shardOf(name)
+		if shard == 1 || (owner != 1 && shard != owner) {
+			t.Errorf("point %s moved from worker %d to %d", name, owner, shard)
+		}
+	}
+	result, lost, err = w.writeFile(file)
+	if err != nil || len(lost) != 0 || result.Sent != len(records) {
+		t.Errorf("the next file wrote %d values with %d batches lost and error %v, want all %d values", result.Sent, len(lost), err, len(records))
+	}
+
+	w.workers[0].dead, w.workers[2].dead = true, true
+	if _, _, err := w.writeFile(file); err == nil {
+		t.Error("writing without live workers did not fail")
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibFTH"
+)
+
+// Confidence of each way a datalog tag is matched to a point, fuzzy matches scale their similarity
+const (
+	confidenceInstrumentTag = 1.0
+	confidenceNormalised    = 0.9
+	confidenceExDesc        = 0.85
+	confidenceFuzzy         = 0.8
+)
+
+// tagMatch is a row of the proposed tag map
+type tagMatch struct {
+	datalogTag string
+	point      *LibFTH.PointInfo
+	confidence float64
+	method     string
+}
+
+// loadPointListCSV reads an exported point list. The header names the columns, the point name is in
+// tag or name, and instrumenttag, exdesc and descriptor are read when they are there.
+func loadPointListCSV(filePath string) ([]LibFTH.PointInfo, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading point list header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	nameColumn, ok := columns["tag"]
+	if !ok {
+		if nameColumn, ok = columns["name"]; !ok {
+			return nil, fmt.Errorf("point list %s has no tag or name column", filePath)
+		}
+	}
+	field := func(record []string, column string) string {
+		if i, ok := columns[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var points []LibFTH.PointInfo
+	for i := 2; ; i++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return points, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV file at line %d: %w", i, err)
+		}
+		if nameColumn >= len(record) || strings.TrimSpace(record[nameColumn]) == "" {
+			continue
+		}
+		points = append(points, LibFTH.PointInfo{
+			Name:          strings.TrimSpace(record[nameColumn]),
+			InstrumentTag: field(record, "instrumenttag"),
+			ExDesc:        field(record, "exdesc"),
+			Descriptor:    field(record, "descriptor"),
+		})
+	}
+}
+
+// normaliseTag keeps only the letters and digits of a name, in upper case, so TEMPERATURES\100 and
+// Temperatures_100 compare equal
+func normaliseTag(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// exDescNames reports whether a word of an exdesc is the normalised datalog tag. Words are split at
+// spaces, commas, semicolons and =, so I=T1 names T1 but I=T10 and PT1 don't.
+func exDescNames(exDesc string, normalised string) bool {
+	if normalised == "" {
+		return false
+	}
+	words := strings.FieldsFunc(exDesc, func(r rune) bool { return unicode.IsSpace(r) || strings.ContainsRune(",;=", r) })
+	for _, word := range words {
+		if normaliseTag(word) == normalised {
+			return true
+		}
+	}
+	return false
+}
+
+// bigrams returns the sorted letter pairs of a normalised name
+func bigrams(s string) []string {
+	runes := []rune(s)
+	if len(runes) < 2 {
+		return []string{s}
+	}
+	pairs := make([]string, len(runes)-1)
+	for i := range pairs {
+		pairs[i] = string(runes[i : i+2])
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// similarity is the Dice coefficient of the bigrams of two names, 1 for the same letter pairs
+func similarity(a []string, b []string) float64 {
+	if len(a)+len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			common++
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return 2 * float64(common) / float64(len(a)+len(b))
+}
+
+// tagMatcher finds the historian point of a datalog tag, trying the surest ways first
+type tagMatcher struct {
+	points       []LibFTH.PointInfo
+	byInstrument map[string][]int
+	byNormalised map[string][]int
+	// grams holds the bigrams of the normalised name, instrument tag and descriptor of each point
+	grams     [][][]string
+	threshold float64
+}
+
+func newTagMatcher(points []LibFTH.PointInfo, threshold float64) *tagMatcher {
+	m := &tagMatcher{points: points, byInstrument: make(map[string][]int), byNormalised: make(map[string][]int), threshold: threshold}
+	for i, p := range points {
+		if p.InstrumentTag != "" {
+			key := strings.ToUpper(p.InstrumentTag)
+			m.byInstrument[key] = append(m.byInstrument[key], i)
+		}
+		var grams [][]string
+		seen := make(map[string]bool)
+		for _, text := range []string{p.Name, p.InstrumentTag, p.Descriptor} {
+			norm := normaliseTag(text)
+			if norm == "" || seen[norm] {
+				continue
+			}
+			seen[norm] = true
+			grams = append(grams, bigrams(norm))
+			if text != p.Descriptor {
+				m.byNormalised[norm] = append(m.byNormalised[norm], i)
+			}
+		}
+		m.grams = append(m.grams, grams)
+	}
+	return m
+}
+
+// match proposes a point for a datalog tag, with no point when nothing is similar enough
+func (m *tagMatcher) match(datalogTag string) tagMatch {
+	if found := m.byInstrument[strings.ToUpper(datalogTag)]; len(found) > 0 {
+		return m.pick(datalogTag, found, confidenceInstrumentTag, "instrumenttag")
+	}
+	norm := normaliseTag(datalogTag)
+	if found := m.byNormalised[norm]; len(found) > 0 {
+		return m.pick(datalogTag, found, confidenceNormalised, "normalised")
+	}
+
+	var found []int
+	for i, p := range m.points {
+		if exDescNames(p.ExDesc, norm) {
+			found = append(found, i)
+		}
+	}
+	if len(found) > 0 {
+		return m.pick(datalogTag, found, confidenceExDesc, "exdesc")
+	}
+
+	tagGrams := bigrams(norm)
+	best, second, bestIndex := 0.0, 0.0, -1
+	for i, grams := range m.grams {
+		score := 0.0
+		for _, g := range grams {
+			score = max(score, similarity(tagGrams, g))
+		}
+		if score > best {
+			best, second, bestIndex = score, best, i
+		} else if score > second {
+			second = score
+		}
+	}
+	if bestIndex < 0 || best < m.threshold {
+		return tagMatch{datalogTag: datalogTag, method: "unmatched"}
+	}
+	result := tagMatch{datalogTag: datalogTag, point: &m.points[bestIndex], confidence: best * confidenceFuzzy, method: "fuzzy"}
+	if best-second < 0.02 {
+		result.confidence /= 2
+		result.method = "fuzzy, ambiguous"
+	}
+	return result
+}
+
+// pick proposes the first of the points found, halving the confidence when there are several
+func (m *tagMatcher) pick(datalogTag string, found []int, confidence float64, method string) tagMatch {
+	sort.Slice(found, func(i, j int) bool { return m.points[found[i]].Name < m.points[found[j]].Name })
+	if len(found) > 1 {
+		return tagMatch{datalogTag: datalogTag, point: &m.points[found[0]], confidence: confidence / 2,
+			method: fmt.Sprintf("%s, ambiguous with %d points", method, len(found))}
+	}
+	return tagMatch{datalogTag: datalogTag, point: &m.points[found[0]], confidence: confidence, method: method}
+}
+
+// runMatchTags proposes a tag map for the datalog tags, from an exported point list or the points of
+// the historian that match mask. The map is written in the format of -tagMapCSV, and the confidence
+// and method of each match to a separate report for review.
+func runMatchTags(dr *LibDAT.DatReader, opts sinkOptions, pointListPath string, mask string, outPath string, reportPath string, threshold float64) error {
+	tags, err := scanDatalogs(dr, nil, false, false)
+	if err != nil {
+		return err
+	}
+
+	var points []LibFTH.PointInfo
+	if pointListPath != "" {
+		if points, err = loadPointListCSV(pointListPath); err != nil {
+			return err
+		}
+		slog.Info(fmt.Sprintf("Read %d points from %s", len(points), pointListPath))
+	} else {
+		slog.Info(fmt.Sprintf("Connecting to piserver at: %s, with process name %s", opts.host, opts.processName))
+		LibFTH.SetProcessName(opts.processName)
+		if err := LibFTH.Connect(opts.host); err != nil {
+			logHint(err, opts.processName)
+			return err
+		}
+		defer LibFTH.Disconnect()
+		if points, err = LibFTH.SearchPoints(mask); err != nil {
+			return err
+		}
+		slog.Info(fmt.Sprintf("Found %d points matching %s on %s", len(points), mask, opts.host))
+	}
+
+	matcher := newTagMatcher(points, threshold)
+	matches := make([]tagMatch, len(tags))
+	counts := make(map[string]int)
+	for i, tag := range tags {
+		matches[i] = matcher.match(tag.PIName)
+		method, _, _ := strings.Cut(matches[i].method, ",")
+		counts[method]++
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].datalogTag < matches[j].datalogTag })
+
+	if err := writeProposedTagMap(matches, outPath); err != nil {
+		return err
+	}
+	if err := writeMatchReport(matches, reportPath); err != nil {
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("Matched %d of %d datalog tags: %d by instrumenttag, %d by normalised name, %d by exdesc, %d fuzzy, %d unmatched",
+		len(tags)-counts["unmatched"], len(tags), counts["instrumenttag"], counts["normalised"], counts["exdesc"], counts["fuzzy"], counts["unmatched"]))
+	slog.Info(fmt.Sprintf("Proposed tag map written to %s, review it with %s", outPath, reportPath))
+	return nil
+}
+
+// writeProposedTagMap writes the datalog tag and proposed point of every match, without a header, so
+// the file loads as a -tagMapCSV. Unmatched tags are written with an empty point, which the loader skips.
+func writeProposedTagMap(matches []tagMatch, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create tag map: %w", err)
+	}
+	defer file.Close()
+	out := csv.NewWriter(file)
+	for _, m := range matches {
+		row := []string{m.datalogTag, ""}
+		if m.point != nil {
+			row[1] = m.point.Name
+		}
+		out.Write(row)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// writeMatchReport writes how every datalog tag was matched, for reviewing the proposed tag map
+func writeMatchReport(matches []tagMatch, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create match report: %w", err)
+	}
+	defer file.Close()
+	out := csv.NewWriter(file)
+	out.Write([]string{"datalog_tag", "pi_point", "confidence", "method", "instrumenttag", "descriptor"})
+	for _, m := range matches {
+		row := []string{m.datalogTag, "", strconv.FormatFloat(m.confidence, 'f', 2, 64), m.method, "", ""}
+		if m.point != nil {
+			row[1], row[4], row[5] = m.point.Name, m.point.InstrumentTag, m.point.Descriptor
+		}
+		out.Write(row)
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/complacentsee/goDatalogConvert/LibFTH"
+	"github.com/complacentsee/goDatalogConvert/LibInflux"
+	"github.com/complacentsee/goDatalogConvert/LibUtil"
+)
+
+func TestNormaliseTag(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{`TEMPERATURES\100`, "TEMPERATURES100"},
+		{"Temperatures_100", "TEMPERATURES100"},
+		{"tank.level-pv 1", "TANKLEVELPV1"},
+		{"Zone1:Temp", "ZONE1TEMP"},
+		{"Über_Temp", "ÜBERTEMP"},
+		{`\_.-`, ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normaliseTag(tt.name); got != tt.want {
+			t.Errorf("normaliseTag(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExDescNames(t *testing.T) {
+	tests := []struct {
+		exDesc string
+		tag    string
+		want   bool
+	}{
+		{`I=STATE\TXT`, `STATE\TXT`, true},
+		{"I=T1", "T1", true},
+		{"I=T10", "T1", false},
+		{"PT1", "T1", false},
+		{"source T1, scan 1s", "T1", true},
+		{"a=1;b=T1", "T1", true},
+		{"I=t_1", "T1", true},
+		{"", "T1", false},
+		{"I=T1", "", false},
+	}
+	for _, tt := range tests {
+		if got := exDescNames(tt.exDesc, normaliseTag(tt.tag)); got != tt.want {
+			t.Errorf("exDescNames(%q, %q) = %v, want %v", tt.exDesc, tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"TEMP100", "TEMP100", 1},
+		{"ABCD", "WXYZ", 0},
+		// AB BC CD against AB BC CE share AB and BC
+		{"ABCD", "ABCE", 2 * 2.0 / 6},
+		// repeated pairs only count as often as both have them
+		{"AAA", "AA", 2 * 1.0 / 3},
+		{"A", "A", 1},
+		{"", "", 1},
+	}
+	for _, tt := range tests {
+		got := similarity(bigrams(tt.a), bigrams(tt.b))
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("similarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if back := similarity(bigrams(tt.b), bigrams(tt.a)); back != got {
+			t.Errorf("similarity of %q and %q is not symmetric: %v and %v", tt.a, tt.b, got, back)
+		}
+	}
+	if got := similarity(nil, nil); got != 0 {
+		t.Errorf("similarity of no pairs is %v, want 0", got)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	points := []LibFTH.PointInfo{
+		{Name: "TANK_TEMP_A", InstrumentTag: `TEMPERATURES\100`},
+		{Name: "TANK_TEMP_B", InstrumentTag: `temperatures\100`},
+		{Name: "LEVEL_1"},
+		{Name: "Level.1"},
+		{Name: "STATE_TXT_PV", ExDesc: `I=STATE\TXT`},
+		{Name: "FIC_7", ExDesc: "I=FLOW20"},
+		{Name: "PRESSURE_INLET_PV"},
+		{Name: "VALVE_ALPHA"},
+		{Name: "VALVE_ALPHB"},
+	}
+	m := newTagMatcher(points, 0.6)
+	tests := []struct {
+		tag        string
+		point      string
+		method     string
+		confidence float64
+	}{
+		// Both instrumenttags match ignoring case, the first by name is proposed at half confidence
+		{`TEMPERATURES\100`, "TANK_TEMP_A", "instrumenttag, ambiguous with 2 points", confidenceInstrumentTag / 2},
+		{"level 1", "LEVEL_1", "normalised, ambiguous with 2 points", confidenceNormalised / 2},
+		{`STATE\TXT`, "STATE_TXT_PV", "exdesc", confidenceExDesc},
+		{"PRESSURE_INLET", "PRESSURE_INLET_PV", "fuzzy", 0},
+		// ALPHA and ALPHB are as similar to ALPH, so the fuzzy match is ambiguous
+		{"VALVE_ALPH", "VALVE_ALPHA", "fuzzy, ambiguous", 0},
+		{"FLOW2", "", "unmatched", 0},
+		{"COMPLETELY_DIFFERENT", "", "unmatched", 0},
+	}
+	for _, tt := range tests {
+		got := m.match(tt.tag)
+		name := ""
+		if got.point != nil {
+			name = got.point.Name
+		}
+		if name != tt.point || got.method != tt.method {
+			t.Errorf("match(%q) = %q by %q, want %q by %q", tt.tag, name, got.method, tt.point, tt.method)
+			continue
+		}
+		if tt.confidence != 0 && got.confidence != tt.confidence {
+			t.Errorf("match(%q) has confidence %v, want %v", tt.tag, got.confidence, tt.confidence)
+		}
+	}
+
+	fuzzy := m.match("PRESSURE_INLET")
+	ambiguous := m.match("VALVE_ALPH")
+	if fuzzy.confidence <= 0 || fuzzy.confidence > confidenceFuzzy {
+		t.Errorf("fuzzy confidence %v is outside (0, %v]", fuzzy.confidence, confidenceFuzzy)
+	}
+	if ambiguous.confidence > confidenceFuzzy/2 {
+		t.Errorf("ambiguous fuzzy confidence %v is not halved", ambiguous.confidence)
+	}
+}
+
+func TestProposedTagMapLoads(t *testing.T) {
+	matches := []tagMatch{
+		{datalogTag: `TEMPERATURES\100`, point: &LibFTH.PointInfo{Name: "TANK_TEMP_A", InstrumentTag: `TEMPERATURES\100`}, confidence: 1, method: "instrumenttag"},
+		{datalogTag: "UNKNOWN", method: "unmatched"},
+	}
+	path := filepath.Join(t.TempDir(), "proposed_tagmap.csv")
+	if err := writeProposedTagMap(matches, path); err != nil {
+		t.Fatal(err)
+	}
+
+	tagMap := make(map[string]string)
+	if err := LibUtil.LoadTagMapCSV(path, tagMap); err != nil {
+		t.Fatal(err)
+	}
+	if len(tagMap) != 1 || tagMap[`TEMPERATURES\100`] != "TANK_TEMP_A" {
+		t.Errorf("loaded tag map %v, want only TEMPERATURES\\100 to TANK_TEMP_A", tagMap)
+	}
+
+	series := make(map[string]LibInflux.Series)
+	if err := LibInflux.LoadSeriesMapCSV(path, series); err != nil {
+		t.Fatal(err)
+	}
+	for tag, s := range series {
+		if s.Measurement != "" || s.Field != "" || len(s.Tags) != 0 {
+			t.Errorf("the proposed map sets influx columns for %s: %+v", tag, s)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "TEMPERATURES\\100,TANK_TEMP_A\nUNKNOWN,\n"; string(data) != want {
+		t.Errorf("wrote %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibFTH"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+// Reconciliation states of a point in a file
+const (
+	verifyOK         = "OK"
+	verifyCompressed = "Compressed"
+	verifyExtra      = "Extra"
+	verifyMismatch   = "Mismatch"
+	verifyMissing    = "Missing"
+	verifyError      = "Error"
+)
+
+const verifyTimeLayout = "2006-01-02 15:04:05.000"
+
+// defaultCompMax is the compression maximum of a new point, used when the attributes can't be read
+const defaultCompMax = 8 * time.Hour
+
+// verifyWriter reads back the archive of every resolved point over the time range of each file and
+// reconciles it with the source records. Nothing is written to the historian.
+type verifyWriter struct {
+	tolerance float64
+	samples   int
+
+	// attributes holds the PointAttributes of each point number
+	attributes sync.Map
+
+	mu     sync.Mutex
+	file   *os.File
+	report *csv.Writer
+	totals map[string]int
+}
+
+func newVerifyWriter(reportPath string, tolerance float64, samples int, opts sinkOptions) (*verifyWriter, error) {
+	slog.Info(fmt.Sprintf("Connecting to piserver at: %s, with process name %s", opts.host, opts.processName))
+	LibFTH.SetProcessName(opts.processName)
+	if err := LibFTH.Connect(opts.host); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(reportPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create verify report: %w", err)
+	}
+	w := &verifyWriter{tolerance: tolerance, samples: max(samples, 2), file: file, report: csv.NewWriter(file), totals: make(map[string]int)}
+	w.report.Write([]string{"file", "point", "type", "source_count", "archive_count", "source_first", "archive_first",
+		"source_last", "archive_last", "samples", "sample_mismatches", "max_deviation", "status"})
+	return w, nil
+}
+
+func (*verifyWriter) writesInOrder() {}
+
+func (*verifyWriter) ResolvePoint(tag *LibDAT.DatTagRecord, targetName string) *LibPI.PointCache {
+	return LibFTH.AddToPIPointCache(tag.Name, tag.ID, 0, targetName)
+}
+
+// sourceValue is a source record as the historian should hold it
+type sourceValue struct {
+	time  time.Time
+	value float64
+}
+
+// WriteFile reconciles the archive with the records of one file, point by point
+func (w *verifyWriter) WriteFile(file datRecord) error {
+	base := filepath.Base(file.FileName)
+	byPoint := make(map[int][]sourceValue)
+	points := make(map[int]*LibPI.PointCache)
+
+	for _, record := range file.Records {
+		if record == nil {
+			continue
+		}
+		point, exists := file.PointLookup.GetPointByDataLogID(record.TagID)
+		if !exists || !point.Process || point.PIId == nil {
+			continue
+		}
+		ival, istat, ok := LibFTH.ConvertValue(point, record.Val)
+		if !ok {
+			continue
+		}
+		points[record.TagID] = point
+		byPoint[record.TagID] = append(byPoint[record.TagID], sourceValue{time: record.TimeStamp, value: expectedValue(point.PIType, record.Val, ival, istat)})
+	}
+
+	counts := make(map[string]int)
+	var rows [][]string
+	for tagID, source := range byPoint {
+		point := points[tagID]
+		sort.Slice(source, func(i, j int) bool { return source[i].time.Before(source[j].time) })
+		row, status := w.reconcile(base, point, source)
+		counts[status]++
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][1] < rows[j][1] })
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, row := range rows {
+		w.report.Write(row)
+	}
+	w.report.Flush()
+	for status, n := range counts {
+		w.totals[status] += n
+	}
+
+	logVerifyCounts(base, counts)
+	return w.report.Error()
+}
+
+// pointAttributes returns the attributes of a point, read once per run. Points whose attributes
+// can't be read are checked with the defaults of a new point.
+func (w *verifyWriter) pointAttributes(point *LibPI.PointCache) LibFTH.PointAttributes {
+	if attrs, ok := w.attributes.Load(*point.PIId); ok {
+		return attrs.(LibFTH.PointAttributes)
+	}
+	attrs, err := LibFTH.GetPointAttributes(*point.PIId)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Checking %s with default compression settings: %v", point.PIName, err))
+		attrs = LibFTH.PointAttributes{CompMax: defaultCompMax}
+	}
+	actual, _ := w.attributes.LoadOrStore(*point.PIId, attrs)
+	return actual.(LibFTH.PointAttributes)
+}
+
+// reconcile compares the source values of one point with its archive events between the first and last source time
+func (w *verifyWriter) reconcile(file string, point *LibPI.PointCache, source []sourceValue) ([]string, string) {
+	first, last := source[0].time, source[len(source)-1].time
+	row := []string{file, point.PIName, point.PIType.String(), strconv.Itoa(len(source)), "", first.Format(verifyTimeLayout), "",
+		last.Format(verifyTimeLayout), "", "", "", "", ""}
+
+	// Compression may drop the first and last values of a file. It archives an event at least every
+	// CompMax, so reading that much further on each side finds the events around them.
+	attrs := w.pointAttributes(point)
+	margin := attrs.CompMax
+	if margin <= 0 {
+		margin = defaultCompMax
+	}
+	margin += time.Second
+	archive, err := LibFTH.CompValues(*point.PIId, first.Add(-margin), last.Add(margin))
+	if err != nil {
+		slog.Error(fmt.Sprintf("%s: error reading archive of %s: %v", file, point.PIName, err))
+		row[12] = verifyError
+		return row, verifyError
+	}
+	if len(archive) == 0 {
+		row[4] = "0"
+		row[12] = verifyMissing
+		return row, verifyMissing
+	}
+
+	// Only the events between the first and last source time are counted
+	inside := 0
+	for _, v := range archive {
+		if (v.Time.After(first) || sameTime(v.Time, first)) && (v.Time.Before(last) || sameTime(v.Time, last)) {
+			if inside == 0 {
+				row[6] = v.Time.Format(verifyTimeLayout)
+			}
+			row[8] = v.Time.Format(verifyTimeLayout)
+			inside++
+		}
+	}
+	row[4] = strconv.Itoa(inside)
+
+	// Compression drops values, so sampled values are checked against the line through the
+	// archived events and accepted within the compression deviation of the point
+	tolerance := w.tolerance
+	if attrs.Compressing {
+		tolerance = max(tolerance, attrs.CompDev)
+	}
+	step := point.PIType != LibPI.PointTypeReal
+	sampled, mismatches := 0, 0
+	maxDeviation := 0.0
+	for _, i := range sampleIndexes(len(source), w.samples) {
+		sampled++
+		stored, ok := interpolate(archive, point.PIType, source[i].time, step)
+		deviation := math.Abs(stored - source[i].value)
+		if !ok || deviation > tolerance {
+			mismatches++
+		}
+		if ok {
+			maxDeviation = max(maxDeviation, deviation)
+		}
+	}
+	row[9] = strconv.Itoa(sampled)
+	row[10] = strconv.Itoa(mismatches)
+	row[11] = strconv.FormatFloat(maxDeviation, 'g', 6, 64)
+
+	status := verifyOK
+	switch {
+	case mismatches > 0:
+		status = verifyMismatch
+	case inside < len(source):
+		status = verifyCompressed
+	case inside > len(source):
+		status = verifyExtra
+	}
+	row[12] = status
+	return row, status
+}
+
+// sameTime compares timestamps to the millisecond, the precision of the datalogs
+func sameTime(a time.Time, b time.Time) bool {
+	return a.Sub(b).Abs() < time.Millisecond/2
+}
+
+// expectedValue is the value the archive returns for a source value of the point type
+func expectedValue(pointType LibPI.PointType, v float64, ival int32, istat int32) float64 {
+	switch pointType {
+	case LibPI.PointTypeInteger:
+		return float64(ival)
+	case LibPI.PointTypeDigital:
+		return float64(istat)
+	default:
+		return v
+	}
+}
+
+func archiveValue(pointType LibPI.PointType, v LibFTH.ArchiveValue) float64 {
+	return expectedValue(pointType, v.Value, v.Ival, v.Istat)
+}
+
+// interpolate finds the archived value of a point at t, on the line between the surrounding
+// events, or holding the previous event for step points
+func interpolate(archive []LibFTH.ArchiveValue, pointType LibPI.PointType, t time.Time, step bool) (float64, bool) {
+	i := sort.Search(len(archive), func(i int) bool { return archive[i].Time.After(t) && !sameTime(archive[i].Time, t) }) - 1
+	if i < 0 {
+		return 0, false
+	}
+	before := archive[i]
+	if sameTime(before.Time, t) || step {
+		return archiveValue(pointType, before), true
+	}
+	if i == len(archive)-1 {
+		return 0, false
+	}
+	after := archive[i+1]
+	frac := float64(t.Sub(before.Time)) / float64(after.Time.Sub(before.Time))
+	v0, v1 := archiveValue(pointType, before), archiveValue(pointType, after)
+	return v0 + (v1-v0)*frac, true
+}
+
+// sampleIndexes spreads up to n samples evenly over count values, including the first and, from two samples on, the last
+func sampleIndexes(count int, n int) []int {
+	if n < 1 {
+		return []int{}
+	}
+	if count <= n {
+		indexes := make([]int, count)
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return indexes
+	}
+	if n == 1 {
+		return []int{0}
+	}
+	indexes := make([]int, n)
+	for k := range indexes {
+		indexes[k] = k * (count - 1) / (n - 1)
+	}
+	return indexes
+}
+
+func logVerifyCounts(scope string, counts map[string]int) {
+	msg := fmt.Sprintf("%s: verified %d points, %d ok, %d compressed, %d with extra events, %d mismatched, %d missing, %d errors",
+		scope, counts[verifyOK]+counts[verifyCompressed]+counts[verifyExtra]+counts[verifyMismatch]+counts[verifyMissing]+counts[verifyError],
+		counts[verifyOK], counts[verifyCompressed], counts[verifyExtra], counts[verifyMismatch], counts[verifyMissing], counts[verifyError])
+	if counts[verifyMismatch]+counts[verifyMissing]+counts[verifyError] > 0 {
+		slog.Warn(msg)
+		return
+	}
+	slog.Info(msg)
+}
+
+// Report logs the totals of the run
+func (w *verifyWriter) Report() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	logVerifyCounts("run total", w.totals)
+	slog.Info(fmt.Sprintf("Reconciliation report written to %s", w.file.Name()))
+}
+
+func (w *verifyWriter) Close() error {
+	w.report.Flush()
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return LibFTH.Disconnect()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibFTH"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+)
+
+func TestInterpolate(t *testing.T) {
+	t0 := time.Date(2024, time.January, 2, 10, 0, 0, 0, time.UTC)
+	archive := []LibFTH.ArchiveValue{
+		{Time: t0, Value: 10, Ival: 1, Istat: 3},
+		{Time: t0.Add(10 * time.Second), Value: 20, Ival: 2, Istat: 4},
+	}
+	tests := []struct {
+		name      string
+		at        time.Time
+		pointType LibPI.PointType
+		step      bool
+		want      float64
+		ok        bool
+	}{
+		{"before the first event", t0.Add(-time.Second), LibPI.PointTypeReal, false, 0, false},
+		{"exact hit", t0, LibPI.PointTypeReal, false, 10, true},
+		{"exact hit within half a millisecond", t0.Add(10*time.Second - 100*time.Microsecond), LibPI.PointTypeReal, false, 20, true},
+		{"interpolated", t0.Add(2500 * time.Millisecond), LibPI.PointTypeReal, false, 12.5, true},
+		{"step holds the previous event", t0.Add(9 * time.Second), LibPI.PointTypeReal, true, 10, true},
+		{"step past the last event", t0.Add(time.Minute), LibPI.PointTypeReal, true, 20, true},
+		{"interpolated past the last event", t0.Add(time.Minute), LibPI.PointTypeReal, false, 0, false},
+		{"exact hit on the last event", t0.Add(10 * time.Second), LibPI.PointTypeReal, false, 20, true},
+		{"integer point", t0.Add(5 * time.Second), LibPI.PointTypeInteger, false, 1.5, true},
+		{"digital point", t0, LibPI.PointTypeDigital, true, 3, true},
+	}
+	for _, tt := range tests {
+		got, ok := interpolate(archive, tt.pointType, tt.at, tt.step)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	if _, ok := interpolate(nil, LibPI.PointTypeReal, t0, false); ok {
+		t.Error("found a value in an empty archive")
+	}
+}
+
+func TestSampleIndexes(t *testing.T) {
+	tests := []struct {
+		count int
+		n     int
+		want  []int
+	}{
+		{0, 10, []int{}},
+		{1, 10, []int{0}},
+		{3, 10, []int{0, 1, 2}},
+		{10, 10, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{11, 2, []int{0, 10}},
+		{101, 5, []int{0, 25, 50, 75, 100}},
+		{10, 4, []int{0, 3, 6, 9}},
+		{10, 1, []int{0}},
+		{10, 0, []int{}},
+	}
+	for _, tt := range tests {
+		got := sampleIndexes(tt.count, tt.n)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sampleIndexes(%d, %d) = %v, want %v", tt.count, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibFTH"
+	"github.com/complacentsee/goDatalogConvert/LibInflux"
+	"github.com/complacentsee/goDatalogConvert/LibMQTT"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+	"github.com/complacentsee/goDatalogConvert/LibPIWeb"
+	"github.com/complacentsee/goDatalogConvert/LibSQLite"
+)
+
+// recordWriter is an output target for decoded datalog records
+type recordWriter interface {
+	ResolvePoint(tag *LibDAT.DatTagRecord, targetName string) *LibPI.PointCache
+	WriteFile(file datRecord) error
+	Close() error
+}
+
+// recordSink is implemented by the output packages that don't need historian point lookups
+type recordSink interface {
+	WriteRecords(records []*LibDAT.DatFloatRecord, pointLookup *LibPI.PointLookup) error
+	Close() error
+}
+
+// stringRecordWriter is implemented by writers that also store the values of string tags
+type stringRecordWriter interface {
+	WriteStringRecords(records []*LibDAT.DatStringRecord, pointLookup *LibPI.PointLookup) error
+}
+
+// incrementalWriter is implemented by writers that remember which DAT files they have already stored
+type incrementalWriter interface {
+	Imported(fileName string) (bool, error)
+}
+
+// orderedWriter is implemented by writers that must receive the files one at a time in file order
+type orderedWriter interface {
+	writesInOrder()
+}
+
+// fileSelector is implemented by writers that only take some of the files in the directory
+type fileSelector interface {
+	Selects(fileName string) bool
+}
+
+// runReporter is implemented by writers that summarize the whole run once all files are written
+type runReporter interface {
+	Report()
+}
+
+type sinkOptions struct {
+	host        string
+	processName string
+	tagMapCSV   string
+	dirPath     string
+	workers     int
+
+	// hosts lists every historian server, host is the first of them
+	hosts         []string
+	failedJournal string
+	replay        *replayJournal
+
+	influxURL         string
+	influxOrg         string
+	influxBucket      string
+	influxFile        string
+	influxMeasurement string
+	influxField       string
+	influxTagKey      string
+	influxBatch       int
+
+	sqliteDB string
+
+	piWebURL   string
+	piWebUser  string
+	piWebBatch int
+	// piWebUpdate is the updateOption of streamsets/recorded
+	piWebUpdate string
+
+	mqtt LibMQTT.Options
+
+	// zone holds the datalog wall-clock times of the sinks that write UTC
+	zone *time.Location
+}
+
+// historianWriter writes records to FactoryTalk Historian through piapi. All piapi calls go
+// through one worker, so files are handed over in order to keep the values of a point in time order.
+type historianWriter struct {
+	mu  sync.Mutex
+	run *LibFTH.WriteResult
+}
+
+func newHistorianWriter() *historianWriter {
+	return &historianWriter{run: LibFTH.NewRunResult()}
+}
+
+func (*historianWriter) writesInOrder() {}
+
+func (*historianWriter) ResolvePoint(tag *LibDAT.DatTagRecord, targetName string) *LibPI.PointCache {
+	return LibFTH.AddToPIPointCache(tag.Name, tag.ID, 0, targetName)
+}
+
+func (w *historianWriter) WriteFile(file datRecord) error {
+	result, err := LibFTH.ConvertDatFloatRecordsToPutSnapshots(file.Records, file.PointLookup)
+	if result != nil {
+		result.Log(filepath.Base(file.FileName))
+		w.mu.Lock()
+		w.run.Merge(result)
+		w.mu.Unlock()
+	}
+	return err
+}
+
+// Report logs what was and wasn't written over the whole run
+func (w *historianWriter) Report() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.run.Log("run total")
+	LibFTH.GetWorkerStats().Log()
+	LibFTH.LogBatchTuning()
+}
+
+func (*historianWriter) Close() error {
+	return LibFTH.Disconnect()
+}
+
+// namedWriter wraps a recordSink that identifies points by their mapped name only
+type namedWriter struct {
+	recordSink
+}
+
+func (namedWriter) ResolvePoint(tag *LibDAT.DatTagRecord, targetName string) *LibPI.PointCache {
+	return namedPoint(tag, targetName)
+}
+
+func (w namedWriter) WriteFile(file datRecord) error {
+	return w.WriteRecords(file.Records, file.PointLookup)
+}
+
+// namedPoint builds the point cache entry for outputs that key values on the mapped tag name
+func namedPoint(tag *LibDAT.DatTagRecord, targetName string) *LibPI.PointCache {
+	return &LibPI.PointCache{
+		DatalogName: tag.Name,
+		DataLogID:   tag.ID,
+		DataLogType: tag.Type,
+		Process:     true,
+		PIName:      targetName,
+	}
+}
+
+// mqttWriter publishes the files one at a time in file order, so a replay keeps the history in time order
+type mqttWriter struct {
+	namedWriter
+}
+
+func (mqttWriter) writesInOrder() {}
+
+// sqliteWriter keeps the string and incremental methods of the sqlite sink visible to main
+type sqliteWriter struct {
+	*LibSQLite.Writer
+}
+
+func (sqliteWriter) ResolvePoint(tag *LibDAT.DatTagRecord, targetName string) *LibPI.PointCache {
+	return namedPoint(tag, targetName)
+}
+
+// WriteFile stores float and string values and records the file so later runs skip it
+func (w sqliteWriter) WriteFile(file datRecord) error {
+	return w.StoreFile(file.FileName, file.Records, file.StringRecords, file.PointLookup)
+}
+
+// piWebWriter resolves points to WebIds before any values are written, and accounts for the values
+// PI Web API rejected like the fth sink does
+type piWebWriter struct {
+	*LibPIWeb.Writer
+	mu  sync.Mutex
+	run *LibFTH.WriteResult
+}
+
+func (w *piWebWriter) ResolvePoint(tag *LibDAT.DatTagRecord, targetName string) *LibPI.PointCache {
+	return w.AddToPointCache(tag.Name, tag.ID, tag.Type, targetName)
+}
+
+func (w *piWebWriter) WriteFile(file datRecord) error {
+	base := filepath.Base(file.FileName)
+	written, err := w.Write(file.Records, file.PointLookup)
+	result := LibFTH.NewWriteResult()
+	result.Batches = written.Batches
+	result.Sent = written.Sent
+	result.Succeeded = written.Succeeded
+	for _, f := range written.Failures {
+		slog.Warn(fmt.Sprintf("%s: PI Web API returned %d for %d values of %s: %s", base, f.Status, len(f.Times), f.Point, f.Message))
+		for _, ts := range f.Times {
+			result.AddNamedFailure(f.Point, f.Code, ts)
+		}
+	}
+	result.Log(base)
+	w.mu.Lock()
+	w.run.Merge(result)
+	w.mu.Unlock()
+	return errors.Join(err, result.Err())
+}
+
+// Report logs what was and wasn't written over the whole run
+func (w *piWebWriter) Report() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.run.Log("run total")
+}
+
+func newRecordWriter(sink string, opts sinkOptions) (recordWriter, error) {
+	switch sink {
+	case "fth":
+		if len(opts.hosts) > 1 || opts.replay != nil {
+			return newFanoutWriter(opts.hosts, opts.workers, opts.processName, opts.failedJournal, opts.replay)
+		}
+		if opts.workers > 0 {
+			return newShardedWriter(opts.workers, opts.host, opts.processName, "")
+		}
+		slog.Info(fmt.Sprintf("Connecting to piserver at: %s, with process name %s", opts.host, opts.processName))
+		LibFTH.SetProcessName(opts.processName)
+		if err := LibFTH.Connect(opts.host); err != nil {
+			return nil, err
+		}
+		return newHistorianWriter(), nil
+
+	case "influx":
+		series := make(map[string]LibInflux.Series)
+		if opts.tagMapCSV != "" {
+			if err := LibInflux.LoadSeriesMapCSV(opts.tagMapCSV, series); err != nil {
+				return nil, fmt.Errorf("failed to load influx columns from tag map: %w", err)
+			}
+		}
+		encoder := LibInflux.NewEncoder(opts.influxMeasurement, opts.influxField, opts.influxTagKey, series)
+		encoder.Location = opts.zone
+
+		if opts.influxFile != "" {
+			slog.Info(fmt.Sprintf("Writing line protocol to %s", opts.influxFile))
+			fw, err := LibInflux.NewFileWriter(opts.influxFile, encoder)
+			if err != nil {
+				return nil, err
+			}
+			return namedWriter{fw}, nil
+		}
+
+		if opts.influxURL == "" {
+			return nil, fmt.Errorf("influx sink requires -influxURL or -influxFile")
+		}
+		slog.Info(fmt.Sprintf("Writing to influx at %s, bucket %s", opts.influxURL, opts.influxBucket))
+		hw, err := LibInflux.NewHTTPWriter(opts.influxURL, opts.influxOrg, opts.influxBucket, os.Getenv("INFLUX_TOKEN"), encoder)
+		if err != nil {
+			return nil, err
+		}
+		hw.BatchSize = opts.influxBatch
+		return namedWriter{hw}, nil
+
+	case "sqlite":
+		if opts.sqliteDB == "" {
+			return nil, fmt.Errorf("sqlite sink requires -sqliteDB")
+		}
+		slog.Info(fmt.Sprintf("Writing to sqlite database %s", opts.sqliteDB))
+		sw, err := LibSQLite.NewWriter(opts.sqliteDB, opts.dirPath)
+		if err != nil {
+			return nil, err
+		}
+		return sqliteWriter{sw}, nil
+
+	case "piwebapi":
+		if opts.piWebURL == "" {
+			return nil, fmt.Errorf("piwebapi sink requires -piWebURL")
+		}
+		slog.Info(fmt.Sprintf("Writing to data server %s through PI Web API at %s", opts.host, opts.piWebURL))
+		pw := LibPIWeb.NewWriter(opts.piWebURL, opts.host)
+		if token := os.Getenv("PIWEBAPI_TOKEN"); token != "" {
+			pw.SetToken(token)
+		} else if opts.piWebUser != "" {
+			pw.SetBasicAuth(opts.piWebUser, os.Getenv("PIWEBAPI_PASSWORD"))
+		}
+		pw.BatchSize = opts.piWebBatch
+		pw.UpdateOption = opts.piWebUpdate
+		pw.Location = opts.zone
+		return &piWebWriter{Writer: pw, run: LibFTH.NewRunResult()}, nil
+
+	case "mqtt":
+		if opts.mqtt.Broker == "" {
+			return nil, fmt.Errorf("mqtt sink requires -mqttBroker")
+		}
+		slog.Info(fmt.Sprintf("Publishing %s payloads to mqtt broker %s", opts.mqtt.Format, opts.mqtt.Broker))
+		opts.mqtt.Password = os.Getenv("MQTT_PASSWORD")
+		opts.mqtt.Location = opts.zone
+		mp, err := LibMQTT.NewPublisher(opts.mqtt)
+		if err != nil {
+			return nil, err
+		}
+		return mqttWriter{namedWriter{mp}}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown sink %q", sink)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/complacentsee/goDatalogConvert/LibDAT"
+	"github.com/complacentsee/goDatalogConvert/LibFTH"
+	"github.com/complacentsee/goDatalogConvert/LibPI"
+	"github.com/complacentsee/goDatalogConvert/LibPIWeb"
+)
+
+func TestPIWebFailuresAccounted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/points":
+			json.NewEncoder(rw).Encode(map[string]string{"WebId": r.URL.Query().Get("path")})
+		case "/streamsets/recorded":
+			rw.WriteHeader(http.StatusMultiStatus)
+			rw.Write([]byte(`{"Items":[
+				{"Substatus":202},
+				{"Substatus":409,"Content":{"Errors":["[-11046] Target Date in Future."]}}
+			]}`))
+		}
+	}))
+	defer server.Close()
+
+	pw := LibPIWeb.NewWriter(server.URL, "pi01")
+	w := &piWebWriter{Writer: pw, run: LibFTH.NewRunResult()}
+	lookup := LibPI.NewPointLookup()
+	var records []*LibDAT.DatFloatRecord
+	for id, name := range []string{"A", "B"} {
+		lookup.AddPoint(w.ResolvePoint(&LibDAT.DatTagRecord{Name: name, ID: id}, name))
+		records = append(records, &LibDAT.DatFloatRecord{TimeStamp: time.Date(2024, time.January, 2, 10, 0, 0, 0, time.UTC), TagID: id, IsValid: true})
+	}
+
+	for i := 0; i < 2; i++ {
+		err := w.WriteFile(datRecord{FileName: "2024 01 02 0000 (Float).DAT", Records: records, PointLookup: lookup})
+		if !errors.Is(err, LibFTH.ErrDataLevel) {
+			t.Fatalf("got error %v, want a data level PIError", err)
+		}
+	}
+	if w.run.Sent != 4 || w.run.Succeeded != 2 || w.run.Failed != 2 {
+		t.Errorf("run totals sent %d, written %d, failed %d, want 4, 2, 2", w.run.Sent, w.run.Succeeded, w.run.Failed)
+	}
+	f := w.run.Failures[LibFTH.FailureKey{Code: -11046, Name: "B"}]
+	if f == nil || f.Count != 2 {
+		t.Errorf("run totals failures %+v, want 2 values of B with -11046", w.run.Failures)
+	}
+}